@@ -0,0 +1,53 @@
+package display
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatBytes formats a byte count using binary units (e.g. "12.3 MB")
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseBytes parses a byte count with an optional binary unit, as written
+// by FormatBytes (e.g. "512", "64KB", "1.5 MB")
+func ParseBytes(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for i, prefix := range "KMGTPE" {
+		if strings.HasSuffix(value, string(prefix)+"B") {
+			multiplier = int64(1) << (10 * (i + 1))
+			value = strings.TrimSuffix(value, string(prefix)+"B")
+			break
+		}
+	}
+	if multiplier == 1 {
+		value = strings.TrimSuffix(value, "B")
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// FormatETA formats a remaining duration for display, or "--" if it is unknown
+func FormatETA(d time.Duration) string {
+	if d < 0 {
+		return "--"
+	}
+	return d.Round(time.Second).String()
+}
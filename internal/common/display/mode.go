@@ -0,0 +1,118 @@
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Mode controls how progress and messages are written to stdout
+type Mode string
+
+const (
+	// ModeAuto uses ModeTTY when stdout is a terminal and ModePlain otherwise
+	ModeAuto Mode = "auto"
+	// ModeTTY redraws a live full-screen display using ANSI escapes
+	ModeTTY Mode = "tty"
+	// ModePlain prints periodic one-line progress updates
+	ModePlain Mode = "plain"
+	// ModeJSON emits newline-delimited JSON progress events
+	ModeJSON Mode = "json"
+	// ModeQuiet prints no progress or informational messages
+	ModeQuiet Mode = "quiet"
+)
+
+const (
+	// Update interval for plain and JSON progress lines
+	DefaultLineUpdateInterval = 5 * time.Second
+)
+
+var (
+	currentMode = ModeTTY
+	outputMu    sync.Mutex
+)
+
+// ParseMode parses a display mode name
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(name); mode {
+	case ModeAuto, ModeTTY, ModePlain, ModeJSON, ModeQuiet:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid display mode '%s' (expected auto, tty, plain, json or quiet)", name)
+	}
+}
+
+// Resolve turns ModeAuto into a concrete mode based on whether stdout is a terminal
+func (m Mode) Resolve() Mode {
+	if m != ModeAuto {
+		return m
+	}
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		return ModeTTY
+	}
+	return ModePlain
+}
+
+// SetMode sets the display mode used by all commands
+func SetMode(mode Mode) {
+	currentMode = mode.Resolve()
+}
+
+// CurrentMode returns the active display mode
+func CurrentMode() Mode {
+	return currentMode
+}
+
+// Printf prints an informational message according to the display mode.
+// Messages are suppressed in quiet mode and wrapped in a message event in JSON mode.
+func Printf(format string, args ...any) {
+	switch currentMode {
+	case ModeQuiet:
+		return
+	case ModeJSON:
+		message := strings.Trim(fmt.Sprintf(format, args...), "\n")
+		if message != "" {
+			EmitJSON("message", map[string]any{"message": message})
+		}
+	default:
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		fmt.Printf(format, args...)
+	}
+}
+
+// Println prints an informational line according to the display mode
+func Println(args ...any) {
+	Printf("%s", fmt.Sprintln(args...))
+}
+
+// Warnf prints a warning to stderr so it never interleaves with progress output
+func Warnf(format string, args ...any) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	fmt.Fprintf(os.Stderr, "Warning: "+format, args...)
+}
+
+// EmitJSON writes a single JSON event line to stdout
+func EmitJSON(event string, fields map[string]any) {
+	line := make(map[string]any, len(fields)+2)
+	for k, v := range fields {
+		line[k] = v
+	}
+	line["event"] = event
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	os.Stdout.Write(append(data, '\n'))
+}
@@ -0,0 +1,201 @@
+package summary
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// SchemaVersion is the version of the run summary document. It is bumped
+// whenever a field is removed or changes meaning; new fields may be added
+// without a version change.
+const SchemaVersion = 1
+
+// Summary is a machine-readable record of a single command run
+type Summary struct {
+	SchemaVersion   int               `json:"schema_version"`
+	Command         string            `json:"command"`
+	Status          string            `json:"status"` // "success" or "failed"
+	Error           string            `json:"error,omitempty"`
+	StartedAt       time.Time         `json:"started_at"`
+	FinishedAt      time.Time         `json:"finished_at"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Config          map[string]string `json:"config"`
+	Split           *Split            `json:"split,omitempty"`
+	Publish         *Publish          `json:"publish,omitempty"`
+	Replay          *Publish          `json:"replay,omitempty"`
+	HECTest         *HECTest          `json:"hec_test,omitempty"`
+}
+
+// Split summarizes a split run
+type Split struct {
+	InputFile    string       `json:"input_file"`
+	InputBytes   int64        `json:"input_bytes"`
+	Output       string       `json:"output"`
+	TotalRecords int          `json:"total_records"`
+	Sourcetypes  []Sourcetype `json:"sourcetypes"`
+	Phases       []Phase      `json:"phases"`
+}
+
+// Sourcetype is the record count written for one sourcetype
+type Sourcetype struct {
+	Sourcetype string `json:"sourcetype"`
+	Records    int    `json:"records"`
+	Output     string `json:"output"`
+}
+
+// Phase is the duration and throughput of one pass over the input
+type Phase struct {
+	Phase            string  `json:"phase"`
+	Bytes            int64   `json:"bytes"`
+	Records          int     `json:"records"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+	BytesPerSecond   float64 `json:"bytes_per_second"`
+	RecordsPerSecond float64 `json:"records_per_second"`
+}
+
+// Publish summarizes a publish or replay run
+type Publish struct {
+	InputDirectory  string          `json:"input_directory,omitempty"`
+	InputFile       string          `json:"input_file,omitempty"` // Dead-letter file, when replaying
+	DryRun          bool            `json:"dry_run"`
+	TotalFiles      int             `json:"total_files"`
+	ProcessedFiles  int             `json:"processed_files"`
+	TotalEvents     int             `json:"total_events"`
+	PublishedEvents int             `json:"published_events"`
+	FailedEvents    int             `json:"failed_events"`
+	EventsPerSecond float64         `json:"events_per_second"`
+	RawBytes        int64           `json:"raw_bytes"`                  // Request payload bytes sent, before compression
+	CompressedBytes int64           `json:"compressed_bytes,omitempty"` // The same payloads after gzip, when compressing
+	Endpoints       []Endpoint      `json:"endpoints"`
+	Files           []File          `json:"files"`
+	RejectedEvents  []RejectedEvent `json:"rejected_events"`
+	DeadLetterFile  string          `json:"dead_letter_file,omitempty"` // Where failed events were written, if any failed
+	StateFile       string          `json:"state_file,omitempty"`       // Checkpoints for resuming the run
+}
+
+// Endpoint is what was sent to one HEC URL
+type Endpoint struct {
+	URL              string  `json:"url"`
+	Healthy          bool    `json:"healthy"` // Whether it was in rotation at the end of the run
+	Requests         int     `json:"requests"`
+	FailedRequests   int     `json:"failed_requests"`
+	Events           int     `json:"events"`
+	Bytes            int64   `json:"bytes"` // Payload bytes, before compression
+	AverageLatencyMS float64 `json:"average_latency_ms"`
+	Removals         int     `json:"removals"` // Times taken out of rotation after consecutive failures
+}
+
+// File is the outcome of publishing one file
+type File struct {
+	File            string  `json:"file"`
+	Sourcetype      string  `json:"sourcetype"`
+	Status          string  `json:"status"` // "success", "partial" (some events rejected), "skipped" (already published) or "failed"
+	Error           string  `json:"error,omitempty"`
+	TotalEvents     int     `json:"total_events"`
+	PublishedEvents int     `json:"published_events"`
+	FailedEvents    int     `json:"failed_events"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	ResumedAtRow    int     `json:"resumed_at_row,omitempty"` // Rows already published by the resumed run
+}
+
+// RejectedEvent is an event HEC refused, identified by where it came from
+type RejectedEvent struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// HECTest summarizes a hec-test run
+type HECTest struct {
+	Stages []Stage `json:"stages"`
+}
+
+// Stage is the outcome of one hec-test stage
+type Stage struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// New starts a summary for the named command
+func New(command string) *Summary {
+	return &Summary{
+		SchemaVersion: SchemaVersion,
+		Command:       command,
+		StartedAt:     time.Now().UTC(),
+		Config:        map[string]string{},
+	}
+}
+
+// SetConfig records the effective value of every flag, redacting secrets
+func (s *Summary) SetConfig(flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" {
+			return
+		}
+		s.Config[f.Name] = RedactValue(f.Name, f.Value.String())
+	})
+}
+
+// Finish stamps the end time and status of the run
+func (s *Summary) Finish(err error) {
+	s.FinishedAt = time.Now().UTC()
+	s.DurationSeconds = s.FinishedAt.Sub(s.StartedAt).Seconds()
+	s.Status = "success"
+	if err != nil {
+		s.Status = "failed"
+		s.Error = err.Error()
+	}
+}
+
+// Marshal returns the indented JSON document
+func (s *Summary) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling summary: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// WriteFile writes the JSON document to path
+func (s *Summary) WriteFile(path string) error {
+	data, err := s.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing summary file: %w", err)
+	}
+	return nil
+}
+
+// IsSecretFlag reports whether a flag holds a credential that must not be
+// written out. Flags naming where to read one from, like --token-file, don't.
+func IsSecretFlag(name string) bool {
+	if strings.HasSuffix(name, "-file") || strings.HasSuffix(name, "-stdin") {
+		return false
+	}
+	return strings.Contains(name, "token") || strings.Contains(name, "secret") || strings.Contains(name, "password")
+}
+
+// urlPassword matches the password in a URL's user info, such as a proxy's
+var urlPassword = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/@\s,\[\]:]*):[^/@\s,\[\]]*@`)
+
+// RedactValue returns a flag's value as it may be written out. Secret flags
+// are replaced entirely, and passwords in URLs are masked the way
+// (*url.URL).Redacted does.
+func RedactValue(name, value string) string {
+	if value == "" {
+		return value
+	}
+	if IsSecretFlag(name) {
+		return "REDACTED"
+	}
+	return urlPassword.ReplaceAllString(value, "${1}:xxxxx@")
+}
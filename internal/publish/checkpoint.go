@@ -0,0 +1,242 @@
+package publish
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateFileName is the name of the state file earlier versions kept in the
+// input directory. It is still resumed from when there is no other.
+const StateFileName = ".spexma-publish-state.json"
+
+// StatePath returns the default state file for an input directory. It is
+// kept under dir, so the input directory is never written to, and named
+// after the input directory's absolute path so each has its own.
+func StatePath(dir, inputDirectory string) (string, error) {
+	absDirectory, err := filepath.Abs(inputDirectory)
+	if err != nil {
+		return "", fmt.Errorf("error resolving input directory: %w", err)
+	}
+	sum := sha256.Sum256([]byte(absDirectory))
+	return filepath.Join(dir, fmt.Sprintf("publish-state-%x.json", sum[:6])), nil
+}
+
+// stateVersion is the version of the state file format
+const stateVersion = 1
+
+// Checkpoint statuses of a file
+const (
+	CheckpointInProgress = "in_progress"
+	CheckpointComplete   = "complete"
+)
+
+// checkpointSaveInterval limits how often progress within a file is written
+// out. A crash can cost at most this much progress, which is sent again on resume.
+const checkpointSaveInterval = time.Second
+
+// PublishState is the document stored in the state file
+type PublishState struct {
+	Version        int                        `json:"version"`
+	InputDirectory string                     `json:"input_directory"`
+	UpdatedAt      time.Time                  `json:"updated_at"`
+	Files          map[string]*FileCheckpoint `json:"files"` // Keyed by file name
+}
+
+// FileCheckpoint is how far a file has been published. Rows and Line only
+// ever cover batches HEC has accepted.
+type FileCheckpoint struct {
+	Status  string    `json:"status"`
+	Rows    int       `json:"rows"`           // Data rows acknowledged from the start of the file
+	Line    int       `json:"line,omitempty"` // CSV line where the last acknowledged row starts
+	Size    int64     `json:"size"`           // Size and modification time of the file, to detect changes
+	ModTime time.Time `json:"mod_time"`
+}
+
+// LoadPublishState reads a state file. It returns nil and no error if the file doesn't exist.
+func LoadPublishState(path string) (*PublishState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+
+	var state PublishState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("state file %s has unsupported version %d", path, state.Version)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*FileCheckpoint)
+	}
+	return &state, nil
+}
+
+// Checkpointer keeps the state file up to date as batches are acknowledged.
+// It is safe for concurrent use.
+type Checkpointer struct {
+	path     string
+	mu       sync.Mutex
+	state    *PublishState
+	resume   map[string]FileCheckpoint // Checkpoints of the run being resumed
+	lastSave time.Time
+	err      error
+}
+
+// NewCheckpointer creates a checkpointer writing to path for the files of
+// directory. If resume is set, files continue from its checkpoints.
+func NewCheckpointer(path, directory string, resume *PublishState) (*Checkpointer, error) {
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving input directory: %w", err)
+	}
+
+	c := &Checkpointer{
+		path: path,
+		state: &PublishState{
+			Version:        stateVersion,
+			InputDirectory: absDirectory,
+			Files:          make(map[string]*FileCheckpoint),
+		},
+		resume: make(map[string]FileCheckpoint),
+	}
+
+	if resume != nil {
+		if resume.InputDirectory != absDirectory {
+			return nil, fmt.Errorf("state file %s is for %s, not %s", path, resume.InputDirectory, absDirectory)
+		}
+		for name, checkpoint := range resume.Files {
+			c.resume[name] = *checkpoint
+			checkpointCopy := *checkpoint
+			c.state.Files[name] = &checkpointCopy
+		}
+	}
+
+	return c, nil
+}
+
+// Path returns the location of the state file
+func (c *Checkpointer) Path() string {
+	return c.path
+}
+
+// Start registers a file about to be published and returns the checkpoint
+// to resume it from. A file that has changed since it was checkpointed
+// can't be resumed safely, so it is an error.
+func (c *Checkpointer) Start(file string) (FileCheckpoint, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return FileCheckpoint{}, fmt.Errorf("error reading file info: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := filepath.Base(file)
+	checkpoint, ok := c.resume[name]
+	if ok && (checkpoint.Size != info.Size() || !checkpoint.ModTime.Equal(info.ModTime())) {
+		return FileCheckpoint{}, fmt.Errorf("%s has changed since it was checkpointed; publish it without --resume", name)
+	}
+	if !ok {
+		checkpoint = FileCheckpoint{Status: CheckpointInProgress, Size: info.Size(), ModTime: info.ModTime()}
+		checkpointCopy := checkpoint
+		c.state.Files[name] = &checkpointCopy
+	}
+	return checkpoint, nil
+}
+
+// Acknowledge records that a file has been published up to and including
+// the given data row
+func (c *Checkpointer) Acknowledge(file string, rows, line int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	checkpoint := c.state.Files[filepath.Base(file)]
+	if checkpoint == nil || rows <= checkpoint.Rows {
+		return
+	}
+	checkpoint.Rows = rows
+	checkpoint.Line = line
+
+	if time.Since(c.lastSave) >= checkpointSaveInterval {
+		c.saveLocked()
+	}
+}
+
+// Complete marks a file as fully published
+func (c *Checkpointer) Complete(file string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if checkpoint := c.state.Files[filepath.Base(file)]; checkpoint != nil {
+		checkpoint.Status = CheckpointComplete
+		c.saveLocked()
+	}
+}
+
+// Flush writes the state file, returning the first error hit writing it during the run
+func (c *Checkpointer) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.saveLocked()
+	return c.err
+}
+
+// saveLocked atomically replaces the state file; c.mu must be held
+func (c *Checkpointer) saveLocked() {
+	c.lastSave = time.Now()
+	c.state.UpdatedAt = c.lastSave.UTC()
+
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		c.keepError(fmt.Errorf("error marshaling state: %w", err))
+		return
+	}
+	if err := writeFileAtomic(c.path, data); err != nil {
+		c.keepError(fmt.Errorf("error writing state file: %w", err))
+	}
+}
+
+// keepError records the first error; c.mu must be held
+func (c *Checkpointer) keepError(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// writeFileAtomic writes data to a temporary file, syncs it and renames it
+// over path, so a crash never leaves a partly written file behind
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
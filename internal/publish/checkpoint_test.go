@@ -0,0 +1,226 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestResumeSendsOnlyUnacknowledgedRows(t *testing.T) {
+	files := map[string]int{"syslog": 30, "sysmon": 100}
+	tests := []struct {
+		name        string
+		concurrency int
+		wantAcked   map[string]int // Rows checkpointed by the stopped run, if known exactly
+	}{
+		{
+			name:        "one sender",
+			concurrency: 1,
+			wantAcked:   map[string]int{"syslog": 30, "sysmon": 20},
+		},
+		{
+			name:        "several senders",
+			concurrency: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestCSVs(t, files)
+			statePath := filepath.Join(t.TempDir(), "state.json")
+
+			// publish runs over dir, resuming from the state file if it exists
+			publish := func(respond func(int, []received) (int, string)) (*Publisher, *fakeHEC, error) {
+				resume, err := LoadPublishState(statePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkpoints, err := NewCheckpointer(statePath, dir, resume)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p, fake := newTestPublisher(t, PublisherConfig{
+					Concurrency: tt.concurrency,
+					BatchSize:   10,
+					Checkpoints: checkpoints,
+				})
+				fake.respond = respond
+				err = p.PublishDirectory(context.Background(), dir)
+				if flushErr := checkpoints.Flush(); flushErr != nil {
+					t.Fatal(flushErr)
+				}
+				return p, fake, err
+			}
+
+			// The first run stops at its sixth request
+			_, first, err := publish(func(request int, _ []received) (int, string) {
+				if request >= 6 {
+					return http.StatusForbidden, `{"text":"Invalid token","code":4}`
+				}
+				return 0, ""
+			})
+			if err == nil {
+				t.Fatal("the first run didn't stop")
+			}
+			state, err := LoadPublishState(statePath)
+			if err != nil || state == nil {
+				t.Fatalf("got state %v and error %v, want the stopped run's state", state, err)
+			}
+
+			second, fake, err := publish(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, rows := range files {
+				acked := 0
+				if checkpoint := state.Files[name+".csv"]; checkpoint != nil {
+					acked = checkpoint.Rows
+				}
+				if want, ok := tt.wantAcked[name]; ok && acked != want {
+					t.Errorf("%s: the stopped run checkpointed %d rows, want %d", name, acked, want)
+				}
+
+				// Acknowledged rows reached HEC before the stop, and only the rest are sent on resume
+				sent := sortedRows(first.byFile()[name])
+				if len(sent) < acked || !slices.Equal(sent[:acked], rowNumbers(1, acked)) {
+					t.Errorf("%s: the stopped run sent rows %v, want it to have sent 1 to %d", name, sent, acked)
+				}
+				if tt.wantAcked != nil && len(sent) != acked {
+					t.Errorf("%s: the stopped run sent %d rows, want only the %d it checkpointed", name, len(sent), acked)
+				}
+				if got := sortedRows(fake.byFile()[name]); !slices.Equal(got, rowNumbers(acked+1, rows)) {
+					t.Errorf("%s: resuming sent rows %v, want %d to %d", name, got, acked+1, rows)
+				}
+			}
+
+			for _, result := range second.GetFileResults() {
+				acked := state.Files[filepath.Base(result.File)].Rows
+				if result.Skipped != (acked == files[result.SourceType]) {
+					t.Errorf("%s: got skipped %v with %d of %d rows checkpointed", result.SourceType, result.Skipped, acked, files[result.SourceType])
+				}
+				if !result.Skipped && result.ResumedAt != acked {
+					t.Errorf("%s: resumed at row %d, want %d", result.SourceType, result.ResumedAt, acked)
+				}
+			}
+		})
+	}
+}
+
+// sortedRows returns the row numbers of received events, sorted
+func sortedRows(rows []string) []int {
+	numbers := make([]int, 0, len(rows))
+	for _, row := range rows {
+		n, _ := strconv.Atoi(row)
+		numbers = append(numbers, n)
+	}
+	slices.Sort(numbers)
+	return numbers
+}
+
+// rowNumbers returns the numbers from first to last
+func rowNumbers(first, last int) []int {
+	numbers := []int{}
+	for n := first; n <= last; n++ {
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+func TestFileStateAcknowledge(t *testing.T) {
+	// batch returns the job for the n'th batch of ten rows, starting at row 1 on line 2
+	batch := func(n int) *batchJob {
+		return &batchJob{start: n * 10, end: n*10 + 10, lastRow: n*10 + 10, lines: []int{n*10 + 2, n*10 + 11}}
+	}
+	tests := []struct {
+		name  string
+		order []int    // Batches in the order they are sent
+		want  []string // Row and line reached after each, or "" if no further
+	}{
+		{
+			name:  "in order",
+			order: []int{0, 1, 2},
+			want:  []string{"10 11", "20 21", "30 31"},
+		},
+		{
+			name:  "later batches wait for the first",
+			order: []int{2, 1, 0},
+			want:  []string{"", "", "30 31"},
+		},
+		{
+			name:  "a gap holds back what follows it",
+			order: []int{0, 2, 3, 1},
+			want:  []string{"10 11", "", "", "40 41"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state fileState
+			for i, n := range tt.order {
+				got := ""
+				if row, line, ok := state.acknowledge(batch(n)); ok {
+					got = fmt.Sprint(row, " ", line)
+				}
+				if got != tt.want[i] {
+					t.Errorf("after batch %d: got %q, want %q", n, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckpointerStart(t *testing.T) {
+	dir := writeTestCSVs(t, map[string]int{"sysmon": 10})
+	file := filepath.Join(dir, "sysmon.csv")
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	checkpoints, err := NewCheckpointer(statePath, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkpoints.Start(file); err != nil {
+		t.Fatal(err)
+	}
+	checkpoints.Acknowledge(file, 5, 6)
+	if err := checkpoints.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadPublishState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unchanged file resumes from its checkpoint
+	resumed, err := NewCheckpointer(statePath, dir, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := resumed.Start(file)
+	if err != nil || checkpoint.Rows != 5 || checkpoint.Line != 6 {
+		t.Errorf("got checkpoint %+v and error %v, want rows 5 and line 6", checkpoint, err)
+	}
+
+	// A changed file can't be
+	if err := os.WriteFile(file, []byte("_time,file,row\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err = NewCheckpointer(statePath, dir, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resumed.Start(file); err == nil || !strings.Contains(err.Error(), "sysmon.csv has changed since it was checkpointed") {
+		t.Errorf("got error %v, want the file to have changed", err)
+	}
+
+	// Nor can another directory's state
+	if _, err := NewCheckpointer(statePath, t.TempDir(), state); err == nil || !strings.Contains(err.Error(), "is for "+state.InputDirectory) {
+		t.Errorf("got error %v, want the state to be for another directory", err)
+	}
+}
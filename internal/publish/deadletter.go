@@ -0,0 +1,160 @@
+package publish
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/thezmc/spexma/internal/publish/hec"
+)
+
+// DeadLetterFileName is the name of the dead-letter file within a run directory
+const DeadLetterFileName = "dead-letter.ndjson"
+
+// DeadLetter is an event that could not be published, as written to a
+// dead-letter file. Event is the HEC envelope exactly as it was sent.
+type DeadLetter struct {
+	FailedAt time.Time `json:"failed_at"`
+	URL      string    `json:"url,omitempty"`      // HEC URL of the failed attempt; unset if the event was never sent
+	Endpoint string    `json:"endpoint,omitempty"` // hec.EndpointEvent or hec.EndpointRaw
+	File     string    `json:"file"`               // CSV file the event was read from
+	Line     int       `json:"line,omitempty"`     // Line in the CSV file where the event's record starts
+	Class    string    `json:"class"`              // hec.Class of the failure
+	Reason   string    `json:"reason"`
+	Event    hec.Event `json:"event"`
+}
+
+// DeadLetterWriter appends failed events to an NDJSON file. The file and its
+// directory are only created once the first event fails, so successful runs
+// leave nothing behind. It is safe for concurrent use.
+type DeadLetterWriter struct {
+	path   string
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	count  int
+	err    error
+}
+
+// NewDeadLetterWriter creates a writer for the dead-letter file at path
+func NewDeadLetterWriter(path string) *DeadLetterWriter {
+	return &DeadLetterWriter{path: path}
+}
+
+// Path returns the location of the dead-letter file
+func (d *DeadLetterWriter) Path() string {
+	return d.path
+}
+
+// Write appends a failed event. After the first write error every later
+// write is dropped; the error is reported by Close.
+func (d *DeadLetterWriter) Write(record DeadLetter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return
+	}
+
+	if d.file == nil {
+		if err := os.MkdirAll(filepath.Dir(d.path), 0o755); err != nil {
+			d.err = fmt.Errorf("error creating run directory: %w", err)
+			return
+		}
+		file, err := os.OpenFile(d.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			d.err = fmt.Errorf("error creating dead-letter file: %w", err)
+			return
+		}
+		d.file = file
+		d.writer = bufio.NewWriter(file)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		d.err = fmt.Errorf("error marshaling dead-letter event: %w", err)
+		return
+	}
+	data = append(data, '\n')
+	if _, err := d.writer.Write(data); err != nil {
+		d.err = fmt.Errorf("error writing dead-letter file: %w", err)
+		return
+	}
+	d.count++
+}
+
+// Count returns the number of events written
+func (d *DeadLetterWriter) Count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.count
+}
+
+// Close flushes and closes the dead-letter file, returning the first error
+// hit while writing it
+func (d *DeadLetterWriter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.file == nil {
+		return d.err
+	}
+	if err := d.writer.Flush(); err != nil && d.err == nil {
+		d.err = fmt.Errorf("error writing dead-letter file: %w", err)
+	}
+	if err := d.file.Close(); err != nil && d.err == nil {
+		d.err = fmt.Errorf("error closing dead-letter file: %w", err)
+	}
+	d.file = nil
+	return d.err
+}
+
+// DeadLetterReader reads the events of a dead-letter file one at a time
+type DeadLetterReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewDeadLetterReader creates a reader for a dead-letter file
+func NewDeadLetterReader(r io.Reader) *DeadLetterReader {
+	scanner := bufio.NewScanner(r)
+	// Events can be much larger than the default 64KiB token limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	return &DeadLetterReader{scanner: scanner}
+}
+
+// Next returns the next event, or io.EOF once the file is exhausted
+func (r *DeadLetterReader) Next() (DeadLetter, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := r.scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+
+		var record DeadLetter
+		if err := json.Unmarshal(data, &record); err != nil {
+			return DeadLetter{}, fmt.Errorf("error parsing dead-letter line %d: %w", r.line, err)
+		}
+		return record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return DeadLetter{}, fmt.Errorf("error reading dead-letter file: %w", err)
+	}
+	return DeadLetter{}, io.EOF
+}
+
+// failureClass names the class of a publishing failure for a dead-letter record
+func failureClass(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return "cancelled"
+	}
+	return hec.Classify(err).String()
+}
@@ -9,8 +9,80 @@ import (
 	"github.com/thezmc/spexma/internal/common/display"
 )
 
-// DisplayProgress shows the publishing progress in the terminal
-func DisplayProgress(progress *Progress, done <-chan struct{}) {
+// DisplayProgress reports the publishing progress of command, such as
+// "publish" or "replay", until done is closed, using the active display mode
+func DisplayProgress(command string, progress *Progress, done <-chan struct{}) {
+	switch display.CurrentMode() {
+	case display.ModeQuiet:
+		<-done
+	case display.ModePlain, display.ModeJSON:
+		displayLines(command, progress, done)
+	default:
+		displayTerminal(progress, done)
+	}
+}
+
+// displayLines prints a progress line (or JSON event) at a fixed interval
+func displayLines(command string, progress *Progress, done <-chan struct{}) {
+	ticker := time.NewTicker(display.DefaultLineUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			processedFiles, totalFiles, publishedEvents, totalEvents, currentFile, elapsed, status := progress.GetStats()
+
+			rateStats := progress.RateStats()
+
+			if display.CurrentMode() == display.ModeJSON {
+				fields := map[string]any{
+					"command":          command,
+					"status":           status,
+					"current_file":     currentFile,
+					"files_processed":  processedFiles,
+					"files_total":      totalFiles,
+					"events_published": publishedEvents,
+					"events_total":     totalEvents,
+					"elapsed_seconds":  elapsed.Seconds(),
+				}
+				if len(rateStats) > 0 {
+					rates := make([]map[string]any, 0, len(rateStats))
+					for _, stat := range rateStats {
+						rates = append(rates, map[string]any{
+							"name":  stat.Name,
+							"unit":  stat.Unit,
+							"rate":  stat.Rate,
+							"limit": stat.Limit,
+						})
+					}
+					fields["rate_limits"] = rates
+				}
+				display.EmitJSON("progress", fields)
+				continue
+			}
+
+			eventPercentage := 0.0
+			if totalEvents > 0 {
+				eventPercentage = float64(publishedEvents) / float64(totalEvents) * 100
+			}
+			rates := ""
+			for _, stat := range rateStats {
+				rates += " | rate " + stat.Name + " " + formatRate(stat)
+			}
+			display.Printf("[%s] %s files %d/%d | events %d/%d (%.1f%%) | elapsed %s%s | %s\n",
+				command, time.Now().Format(time.TimeOnly),
+				processedFiles, totalFiles,
+				publishedEvents, totalEvents, eventPercentage,
+				elapsed.Round(time.Second), rates, status)
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// displayTerminal redraws a live full-screen display of the publishing progress
+func displayTerminal(progress *Progress, done <-chan struct{}) {
 	// Set up styles
 	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFFF88")).MarginBottom(1)
 	headerStyle := lipgloss.NewStyle().Bold(true).Underline(true).Foreground(lipgloss.Color("#FFFFFF"))
@@ -61,6 +133,15 @@ func DisplayProgress(progress *Progress, done <-chan struct{}) {
 				fmt.Printf("%s %s\n", labelStyle.Render("Current File:"), valueStyle.Render(currentFile))
 			}
 
+			// Show the current rate against each rate limit
+			if rateStats := progress.RateStats(); len(rateStats) > 0 {
+				fmt.Println()
+				fmt.Println(headerStyle.Render("Rate Limits"))
+				for _, stat := range rateStats {
+					fmt.Printf("%s %s\n", labelStyle.Render(stat.Name+":"), valueStyle.Render(formatRate(stat)))
+				}
+			}
+
 			// Show progress bar for events
 			if totalEvents > 0 {
 				width, _ := display.GetTerminalSize()
@@ -90,3 +171,10 @@ func DisplayProgress(progress *Progress, done <-chan struct{}) {
 	}
 }
 
+// formatRate formats a measured rate next to its limit, e.g. "950/s of 1000 events/s"
+func formatRate(stat RateStat) string {
+	if stat.Unit == "bytes" {
+		return fmt.Sprintf("%s/s of %s/s", display.FormatBytes(int64(stat.Rate)), display.FormatBytes(int64(stat.Limit)))
+	}
+	return fmt.Sprintf("%.0f/s of %.0f %s/s", stat.Rate, stat.Limit, stat.Unit)
+}
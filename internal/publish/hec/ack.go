@@ -0,0 +1,99 @@
+package hec
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Defaults for indexer acknowledgement
+const (
+	DefaultAckTimeout      = 2 * time.Minute
+	DefaultAckPollInterval = time.Second
+)
+
+// ErrAckTimeout is returned when HEC doesn't acknowledge a request in time.
+// The events may or may not have been indexed, so the request is resent.
+var ErrAckTimeout = errors.New("timed out waiting for indexer acknowledgement")
+
+// NewChannel returns a random channel GUID for the X-Splunk-Request-Channel header
+func NewChannel() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// ackIDs returns the IDs to wait for, given the ackId of a response
+func ackIDs(ackID *int64) []int64 {
+	if ackID == nil {
+		return nil
+	}
+	return []int64{*ackID}
+}
+
+// waitForAcks polls the ack endpoint until every request is acknowledged,
+// returning ErrAckTimeout if that takes longer than the ack timeout, or
+// ctx's error if it is cancelled first
+func (c *Client) waitForAcks(ctx context.Context, e *endpoint, ids []int64) error {
+	if !c.UseACK || len(ids) == 0 {
+		return nil
+	}
+
+	ackURL, err := c.endpointURL(e.url, "ack")
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		pending[id] = true
+	}
+	deadline := time.Now().Add(c.AckTimeout)
+
+	for {
+		// HEC doesn't acknowledge anything before it has been indexed, so wait first
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for acknowledgement: %w", ctx.Err())
+		case <-time.After(c.AckPollInterval):
+		}
+
+		query := make([]int64, 0, len(pending))
+		for id := range pending {
+			query = append(query, id)
+		}
+		payload, err := json.Marshal(map[string][]int64{"acks": query})
+		if err != nil {
+			return fmt.Errorf("error marshaling ack request: %w", err)
+		}
+
+		status, err := c.post(ctx, ackURL, "application/json", "", bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("error polling for acknowledgement: %w", err)
+		}
+		for id := range pending {
+			if status.Acks[strconv.FormatInt(id, 10)] {
+				delete(pending, id)
+			}
+		}
+
+		if len(pending) == 0 {
+			if c.Debug {
+				log.Printf("DEBUG: Acknowledged ackIds %v", ids)
+			}
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %d of %d requests not acknowledged after %s", ErrAckTimeout, len(pending), len(ids), c.AckTimeout)
+		}
+	}
+}
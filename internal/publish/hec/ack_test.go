@@ -0,0 +1,175 @@
+package hec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAckHEC is an in-process HEC with indexer acknowledgement enabled. Each
+// request gets the next ackId, which is acknowledged once it has been polled
+// pollsToAck times (never if pollsToAck is 0).
+type fakeAckHEC struct {
+	mu         sync.Mutex
+	pollsToAck int
+	nextAckID  int64
+	polls      map[int64]int // Times each ackId has been polled
+	channels   []string      // X-Splunk-Request-Channel of every request
+	ackPolls   int
+	omitAckID  bool // Answer as a token without indexer acknowledgement
+}
+
+func newFakeAckHEC(pollsToAck int) *fakeAckHEC {
+	return &fakeAckHEC{pollsToAck: pollsToAck, polls: make(map[int64]int)}
+}
+
+func (f *fakeAckHEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.channels = append(f.channels, r.Header.Get("X-Splunk-Request-Channel"))
+
+	switch r.URL.Path {
+	case "/services/collector/event":
+		if f.omitAckID {
+			fmt.Fprint(w, `{"text":"Success","code":0}`)
+			return
+		}
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, f.nextAckID)
+		f.nextAckID++
+
+	case "/services/collector/ack":
+		f.ackPolls++
+		var query struct {
+			Acks []int64 `json:"acks"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"text":"Invalid data format","code":6}`)
+			return
+		}
+		acks := make(map[string]bool, len(query.Acks))
+		for _, id := range query.Acks {
+			f.polls[id]++
+			acks[strconv.FormatInt(id, 10)] = f.pollsToAck > 0 && f.polls[id] >= f.pollsToAck
+		}
+		json.NewEncoder(w).Encode(map[string]any{"acks": acks})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"text":"The requested URL was not found on this server.","code":404}`)
+	}
+}
+
+// newAckClient returns a client using indexer acknowledgement against fake
+func newAckClient(t *testing.T, fake *fakeAckHEC, ackTimeout time.Duration) *Client {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL+"/services/collector/event", "secret", &Options{
+		BatchSize:       2,
+		UseACK:          true,
+		AckTimeout:      ackTimeout,
+		AckPollInterval: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func testEvents(n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{Event: map[string]any{"message": fmt.Sprintf("event %d", i)}}
+	}
+	return events
+}
+
+func TestSendEventsWaitsForAcks(t *testing.T) {
+	fake := newFakeAckHEC(2)
+	client := newAckClient(t, fake, time.Minute)
+
+	if err := client.SendEvents(context.Background(), testEvents(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	// Every request, including the ack polls, is on the client's channel
+	if client.Channel == "" {
+		t.Fatal("no channel was generated for indexer acknowledgement")
+	}
+	for i, channel := range fake.channels {
+		if channel != client.Channel {
+			t.Errorf("request %d was on channel %q, want %q", i, channel, client.Channel)
+		}
+	}
+
+	// Three requests of up to two events, each polled until acknowledged
+	var ids []int64
+	for id, polls := range fake.polls {
+		ids = append(ids, id)
+		if polls != 2 {
+			t.Errorf("ackId %d was polled %d times, want 2", id, polls)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if fmt.Sprint(ids) != "[0 1 2]" {
+		t.Errorf("polled ackIds %v, want [0 1 2]", ids)
+	}
+	if fake.ackPolls != 2 {
+		t.Errorf("got %d ack polls, want 2 covering all pending ackIds", fake.ackPolls)
+	}
+}
+
+func TestSendEventsAckTimeout(t *testing.T) {
+	fake := newFakeAckHEC(0)
+	client := newAckClient(t, fake, 30*time.Millisecond)
+
+	err := client.SendEvents(context.Background(), testEvents(3))
+	if !errors.Is(err, ErrAckTimeout) {
+		t.Fatalf("got error %v, want ErrAckTimeout", err)
+	}
+	if Classify(err) != ClassRetryable {
+		t.Errorf("ack timeout classified as %s, want retryable", Classify(err))
+	}
+}
+
+func TestSendEventsAckCancelled(t *testing.T) {
+	fake := newFakeAckHEC(0)
+	client := newAckClient(t, fake, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	err := client.SendEvents(ctx, testEvents(3))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the context's error", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("cancelled wait for acknowledgement took %s", elapsed)
+	}
+}
+
+func TestSendEventsAckDisabled(t *testing.T) {
+	fake := newFakeAckHEC(1)
+	fake.omitAckID = true
+	client := newAckClient(t, fake, time.Minute)
+
+	err := client.SendEvents(context.Background(), testEvents(1))
+	var hecErr *Error
+	if !errors.As(err, &hecErr) || hecErr.Code != CodeACKDisabled {
+		t.Fatalf("got error %v, want a CodeACKDisabled error", err)
+	}
+}
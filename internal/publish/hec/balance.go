@@ -0,0 +1,276 @@
+package hec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Ways of balancing requests across several HEC URLs
+const (
+	BalanceRoundRobin   = "round-robin"   // Take turns
+	BalanceLeastLatency = "least-latency" // Prefer the URL that has been responding fastest
+)
+
+// Defaults for taking unhealthy URLs out of rotation
+const (
+	DefaultUnhealthyAfter      = 3
+	DefaultHealthCheckInterval = 10 * time.Second
+)
+
+// latencyWeight is how much each request moves a URL's latency estimate
+const latencyWeight = 0.3
+
+// EndpointStats are the requests sent to one HEC URL
+type EndpointStats struct {
+	URL            string
+	Healthy        bool
+	Requests       int           // Requests sending events
+	FailedRequests int           // Of Requests
+	Events         int           // Events delivered
+	Bytes          int64         // Payload bytes sent, before compression
+	TotalLatency   time.Duration // Summed over Requests
+	Removals       int           // Times taken out of rotation as unhealthy
+}
+
+// AverageLatency returns the mean response time of the URL's requests
+func (s EndpointStats) AverageLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+// endpoint is one HEC URL the client balances requests across
+type endpoint struct {
+	url string
+
+	mu        sync.Mutex
+	failures  int           // Consecutive failed sends
+	latency   time.Duration // Moving average of the response time
+	checking  bool          // A health check is running
+	nextCheck time.Time     // When an unhealthy URL is next checked
+	stats     EndpointStats
+}
+
+// healthy reports whether the endpoint is in rotation
+func (e *endpoint) healthy() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stats.Healthy
+}
+
+// observe records a request to the endpoint
+func (e *endpoint) observe(bytes int, latency time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stats.Requests++
+	e.stats.Bytes += int64(bytes)
+	e.stats.TotalLatency += latency
+	if err != nil {
+		e.stats.FailedRequests++
+	}
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency += time.Duration(latencyWeight * float64(latency-e.latency))
+	}
+}
+
+// newEndpoints returns the endpoints for a client's URLs, all in rotation
+func newEndpoints(urls []string) []*endpoint {
+	endpoints := make([]*endpoint, 0, len(urls))
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		endpoints = append(endpoints, &endpoint{url: u, stats: EndpointStats{URL: u, Healthy: true}})
+	}
+	return endpoints
+}
+
+// pickEndpoint returns the URL to send the next events to. If every URL is
+// unhealthy, they are all tried rather than giving up.
+func (c *Client) pickEndpoint() *endpoint {
+	if len(c.endpoints) == 1 {
+		return c.endpoints[0]
+	}
+
+	candidates := make([]*endpoint, 0, len(c.endpoints))
+	for _, e := range c.endpoints {
+		if e.healthy() {
+			candidates = append(candidates, e)
+		} else {
+			c.recheck(e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = c.endpoints
+	}
+
+	if c.Balance == BalanceLeastLatency {
+		var best *endpoint
+		var bestLatency time.Duration
+		for _, e := range candidates {
+			e.mu.Lock()
+			latency := e.latency
+			e.mu.Unlock()
+			if best == nil || latency < bestLatency {
+				best, bestLatency = e, latency
+			}
+		}
+		return best
+	}
+
+	return candidates[c.nextEndpoint.Add(1)%uint64(len(candidates))]
+}
+
+// finish records the outcome of sending events to an endpoint. Failures
+// that suggest the URL itself is in trouble count towards taking it out of
+// rotation; rejected data and configuration errors don't.
+func (c *Client) finish(e *endpoint, events int, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err == nil {
+		e.failures = 0
+		e.stats.Events += events
+		return
+	}
+	if Classify(err) != ClassRetryable || len(c.endpoints) == 1 {
+		return
+	}
+
+	e.failures++
+	if e.stats.Healthy && e.failures >= c.UnhealthyAfter {
+		e.stats.Healthy = false
+		e.stats.Removals++
+		e.nextCheck = time.Now().Add(c.HealthCheckInterval)
+		if c.OnHealthChange != nil {
+			c.OnHealthChange(e.url, false, err)
+		}
+	}
+}
+
+// recheck starts a health check of an unhealthy endpoint once it is due,
+// putting it back in rotation if it passes
+func (c *Client) recheck(e *endpoint) {
+	e.mu.Lock()
+	if e.checking || time.Now().Before(e.nextCheck) {
+		e.mu.Unlock()
+		return
+	}
+	e.checking = true
+	e.mu.Unlock()
+
+	go func() {
+		err := c.checkHealth(e.url)
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.checking = false
+		if err != nil {
+			if c.Debug {
+				log.Printf("DEBUG: %s is still unhealthy: %v", e.url, err)
+			}
+			e.nextCheck = time.Now().Add(c.HealthCheckInterval)
+			return
+		}
+		e.stats.Healthy = true
+		e.failures = 0
+		e.latency = 0 // Measure it afresh
+		if c.OnHealthChange != nil {
+			c.OnHealthChange(e.url, true, nil)
+		}
+	}()
+}
+
+// checkHealth queries the HEC health endpoint next to a collector URL
+func (c *Client) checkHealth(baseURL string) error {
+	healthURL, err := c.endpointURL(baseURL, "health")
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", healthURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Splunk "+c.Token)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HEC health endpoint returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// EndpointStats returns the stats of each HEC URL, in the order they were given
+func (c *Client) EndpointStats() []EndpointStats {
+	stats := make([]EndpointStats, 0, len(c.endpoints))
+	for _, e := range c.endpoints {
+		e.mu.Lock()
+		stats = append(stats, e.stats)
+		e.mu.Unlock()
+	}
+	return stats
+}
+
+// HealthCheck validates connectivity to every HEC URL by sending each a test
+// event. URLs that fail are taken out of rotation; it is only an error if
+// none pass.
+func (c *Client) HealthCheck() error {
+	// Send a minimal event to check connectivity
+	testEvent := Event{
+		Event: map[string]interface{}{
+			"message": "HEC connection test from spexma",
+		},
+		SourceType: "spexma:test",
+	}
+
+	if c.Debug {
+		log.Println("DEBUG: Performing HEC health check")
+	}
+
+	var errs []error
+	for _, e := range c.endpoints {
+		err := c.sendEvent(context.Background(), e, testEvent)
+		if err == nil {
+			continue
+		}
+		if len(c.endpoints) == 1 {
+			return fmt.Errorf("HEC health check failed: %w", err)
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", e.url, err))
+		e.mu.Lock()
+		e.stats.Healthy = false
+		e.stats.Removals++
+		e.nextCheck = time.Now().Add(c.HealthCheckInterval)
+		e.mu.Unlock()
+		if c.OnHealthChange != nil {
+			c.OnHealthChange(e.url, false, err)
+		}
+	}
+	if len(errs) == len(c.endpoints) {
+		return fmt.Errorf("HEC health check failed for every URL: %w", errors.Join(errs...))
+	}
+
+	if c.Debug {
+		log.Println("DEBUG: HEC health check passed successfully")
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package hec
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSendEncodedFailedURL checks that a failed send names the URL the
+// balancer sent it to, not the client's first URL
+func TestSendEncodedFailedURL(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"text":"Invalid data format","code":6,"invalid-event-number":0}`)
+	}))
+	defer bad.Close()
+
+	goodURL := good.URL + "/services/collector/event"
+	badURL := bad.URL + "/services/collector/event"
+	client, err := NewClient(goodURL, "secret", &Options{URLs: []string{badURL}, Balance: BalanceRoundRobin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := client.Encode(Event{Event: map[string]any{"message": "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failed []string
+	for i := 0; i < 4; i++ {
+		if err := client.SendEncoded(context.Background(), []EncodedEvent{encoded}); err != nil {
+			if Classify(err) != ClassRejected {
+				t.Errorf("got %s error %v, want rejected", Classify(err), err)
+			}
+			failed = append(failed, FailedURL(err))
+		}
+	}
+	if fmt.Sprint(failed) != fmt.Sprint([]string{badURL, badURL}) {
+		t.Errorf("got failures at %v, want two at %s", failed, badURL)
+	}
+}
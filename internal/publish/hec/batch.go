@@ -0,0 +1,111 @@
+package hec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxBatchBytes is the default limit on the payload size of a request.
+// It matches the max_content_length Splunk has long shipped with, which
+// Splunk Cloud still enforces.
+const DefaultMaxBatchBytes = 1000000
+
+// EventTooLargeError is returned for an event whose payload alone exceeds the
+// request size limit, so it can't be sent in any batch. The events ahead of
+// it in the same call have been sent.
+type EventTooLargeError struct {
+	Index int // Index of the event in the events passed to the client
+	Size  int // Payload bytes the event needs
+	Limit int // The request size limit
+}
+
+// Error returns a description of the oversized event
+func (e *EventTooLargeError) Error() string {
+	return fmt.Sprintf("event is too large to send: %d bytes exceeds the %d byte request limit", e.Size, e.Limit)
+}
+
+// applyDefaults fills in the event metadata the client has defaults for
+func (c *Client) applyDefaults(event *Event) {
+	if event.Index == "" {
+		event.Index = c.DefaultIndex
+	}
+	if event.Host == "" {
+		event.Host = c.DefaultHost
+	}
+	if event.Source == "" {
+		event.Source = c.DefaultSource
+	}
+}
+
+// EncodedEvent is an event with the payload bytes it adds to a request to
+// the client's endpoint, separator included. Events are encoded once, as
+// they are read, and the same bytes size their batch, count against rate
+// limits and make up the request.
+type EncodedEvent struct {
+	Event
+	Payload []byte
+}
+
+// Encode applies the client's defaults to an event and encodes it for the
+// client's endpoint: as JSON for the event endpoint, or as its raw text
+// line for the raw endpoint
+func (c *Client) Encode(event Event) (EncodedEvent, error) {
+	c.applyDefaults(&event)
+
+	if c.Endpoint == EndpointRaw {
+		raw, _ := event.Event[RawField].(string)
+		payload := make([]byte, 0, len(raw)+1)
+		payload = append(payload, raw...)
+		if !strings.HasSuffix(raw, "\n") {
+			payload = append(payload, '\n')
+		}
+		return EncodedEvent{Event: event, Payload: payload}, nil
+	}
+
+	// The encoder ends the JSON with a newline, which becomes the separator
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(event); err != nil {
+		return EncodedEvent{}, fmt.Errorf("error marshaling event: %w", err)
+	}
+	payload := buf.Bytes()
+	payload[len(payload)-1] = ','
+	return EncodedEvent{Event: event, Payload: payload}, nil
+}
+
+// encodeAll encodes events for the client's endpoint
+func (c *Client) encodeAll(events []Event) ([]EncodedEvent, error) {
+	encoded := make([]EncodedEvent, len(events))
+	for i, event := range events {
+		var err error
+		if encoded[i], err = c.Encode(event); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+	return encoded, nil
+}
+
+// PayloadOverhead returns the payload bytes of a request to the client's
+// endpoint beyond those of its events
+func (c *Client) PayloadOverhead() int {
+	if c.Endpoint == EndpointRaw {
+		return 0
+	}
+	return 1 // The event endpoint's events are sent as a JSON array
+}
+
+// tooLarge returns an EventTooLargeError if an event of size bytes can't fit in a request
+func (c *Client) tooLarge(index, size int) error {
+	if c.PayloadOverhead()+size <= c.MaxBatchBytes {
+		return nil
+	}
+	return &EventTooLargeError{Index: index, Size: c.PayloadOverhead() + size, Limit: c.MaxBatchBytes}
+}
+
+// IsEventTooLarge reports whether err is, or wraps, an EventTooLargeError
+func IsEventTooLarge(err error) bool {
+	var tooLargeErr *EventTooLargeError
+	return errors.As(err, &tooLargeErr)
+}
@@ -0,0 +1,129 @@
+package hec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	ts := int64(1700000000)
+	tests := []struct {
+		name     string
+		endpoint string
+		event    Event
+		want     string
+	}{
+		{
+			name:     "event endpoint with defaults",
+			endpoint: EndpointEvent,
+			event:    Event{Time: &ts, SourceType: "syslog", Event: map[string]any{"message": "a<b"}},
+			want:     `{"time":1700000000,"host":"web01","sourcetype":"syslog","index":"main","event":{"message":"a\u003cb"}},`, // Escaped as json.Marshal does
+		},
+		{
+			name:     "event endpoint keeps the event's own index",
+			endpoint: EndpointEvent,
+			event:    Event{Index: "other", Event: map[string]any{"n": 1}},
+			want:     `{"host":"web01","index":"other","event":{"n":1}},`,
+		},
+		{
+			name:     "raw endpoint adds the line's newline",
+			endpoint: EndpointRaw,
+			event:    Event{Event: map[string]any{RawField: "Jan 1 00:00:00 web01 sshd"}},
+			want:     "Jan 1 00:00:00 web01 sshd\n",
+		},
+		{
+			name:     "raw endpoint keeps an existing newline",
+			endpoint: EndpointRaw,
+			event:    Event{Event: map[string]any{RawField: "line\n"}},
+			want:     "line\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient("http://localhost:8088/services/collector", "secret", &Options{
+				Endpoint:     tt.endpoint,
+				DefaultIndex: "main",
+				DefaultHost:  "web01",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := client.Encode(tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded.Payload) != tt.want {
+				t.Errorf("got payload %q, want %q", encoded.Payload, tt.want)
+			}
+			if encoded.Host != "web01" {
+				t.Errorf("encoded event has host %q, want the default", encoded.Host)
+			}
+		})
+	}
+}
+
+// TestSendEncodedBatchBytes checks that requests are made of the encoded
+// payloads and stay within the byte limit
+func TestSendEncodedBatchBytes(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	}))
+	defer server.Close()
+
+	const limit = 200
+	client, err := NewClient(server.URL+"/services/collector/event", "secret", &Options{
+		BatchSize:     100,
+		MaxBatchBytes: limit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []EncodedEvent
+	for i := 0; i < 20; i++ {
+		encoded, err := client.Encode(Event{Event: map[string]any{"message": fmt.Sprintf("event %02d", i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, encoded)
+	}
+	if err := client.SendEncoded(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	for _, body := range bodies {
+		if len(body) > limit {
+			t.Errorf("request of %d bytes exceeds the %d byte limit", len(body), limit)
+		}
+		var batch []struct {
+			Event map[string]string `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(body), &batch); err != nil {
+			t.Fatalf("request isn't a JSON array of events: %v\n%s", err, body)
+		}
+		for _, event := range batch {
+			sent = append(sent, event.Event["message"])
+		}
+	}
+	if len(bodies) < 2 {
+		t.Errorf("got %d requests, want the events split across several", len(bodies))
+	}
+	if got := strings.Join(sent, ","); !strings.HasPrefix(got, "event 00,event 01") || len(sent) != 20 {
+		t.Errorf("got events %s, want all 20 in order", got)
+	}
+}
@@ -2,12 +2,17 @@ package hec
 
 import (
 	"bytes"
-	"crypto/tls"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +24,7 @@ type Event struct {
 	SourceType string         `json:"sourcetype,omitempty"`
 	Index      string         `json:"index,omitempty"`
 	Event      map[string]any `json:"event"`
+	Fields     map[string]any `json:"fields,omitempty"` // Indexed fields; not sent to the raw endpoint
 }
 
 // Client represents a Splunk HEC client
@@ -28,12 +34,35 @@ type Client struct {
 	InsecureSSL     bool
 	Timeout         time.Duration
 	BatchSize       int
+	MaxBatchBytes   int // Limit on the payload size of a request
 	HTTPClient      *http.Client
 	DefaultIndex    string
 	DefaultHost     string
 	DefaultSource   string
 	DefaultMetadata map[string]string
 	Debug           bool
+	Channel         string        // Request channel GUID, sent with every request when set
+	UseACK          bool          // Wait for indexer acknowledgement of every request
+	AckTimeout      time.Duration // How long to wait for acknowledgement before giving up
+	AckPollInterval time.Duration // How often to poll for acknowledgement
+	Endpoint        string        // EndpointEvent or EndpointRaw
+	RawEventTime    bool          // Pass each event's time to the raw endpoint instead of letting Splunk extract it
+	Gzip            bool          // Compress request bodies
+	GzipLevel       int           // Compression level, from gzip.BestSpeed to gzip.BestCompression
+
+	// With several URLs, requests are spread across them by Balance. A URL
+	// is taken out of rotation after UnhealthyAfter consecutive failures and
+	// health checked every HealthCheckInterval until it recovers.
+	Balance             string
+	UnhealthyAfter      int
+	HealthCheckInterval time.Duration
+	OnHealthChange      func(url string, healthy bool, err error) // Called when a URL leaves or rejoins the rotation
+
+	endpoints    []*endpoint   // URL followed by any other URLs from Options
+	nextEndpoint atomic.Uint64 // Round-robin position
+	gzipWriters  sync.Pool     // Reusable writers at GzipLevel
+	rawBytes     atomic.Int64  // Event payload bytes sent, before compression
+	wireBytes    atomic.Int64  // Event payload bytes sent, as written to the network
 }
 
 // Options for configuring the HEC client
@@ -41,22 +70,49 @@ type Options struct {
 	InsecureSSL     bool
 	Timeout         time.Duration
 	BatchSize       int
+	MaxBatchBytes   int // Defaults to DefaultMaxBatchBytes
+	Concurrency     int // Requests made at once, each of which keeps a connection to its URL open
 	DefaultIndex    string
 	DefaultHost     string
 	DefaultSource   string
 	DefaultMetadata map[string]string
 	Debug           bool
+	Channel         string // Request channel GUID; generated when UseACK is set and this is empty
+	UseACK          bool
+	AckTimeout      time.Duration
+	AckPollInterval time.Duration
+	Endpoint        string // Defaults to EndpointEvent
+	RawEventTime    bool
+	Gzip            bool
+	GzipLevel       int // Defaults to gzip.DefaultCompression
+
+	CAFile         string // PEM CA bundle to trust along with the system's CAs
+	ClientCertFile string // PEM client certificate for mutual TLS, with ClientKeyFile
+	ClientKeyFile  string
+	ServerName     string // Name to verify the server certificate against, instead of the URL's host
+	MinTLSVersion  string // "1.0" to "1.3"; defaults to Go's minimum
+	ProxyURL       string // http, https or socks5 proxy; defaults to HTTPS_PROXY and friends
+
+	URLs                []string // More HEC URLs to balance requests across along with the client's URL
+	Balance             string   // Defaults to BalanceRoundRobin
+	UnhealthyAfter      int      // Defaults to DefaultUnhealthyAfter
+	HealthCheckInterval time.Duration
+	OnHealthChange      func(url string, healthy bool, err error)
 }
 
 // Response from the Splunk HEC API
 type Response struct {
-	Text    string `json:"text"`
-	Code    int    `json:"code"`
-	Invalid int    `json:"invalid,omitempty"`
+	Text               string          `json:"text"`
+	Code               int             `json:"code"`
+	Invalid            int             `json:"invalid,omitempty"`
+	InvalidEventNumber *int            `json:"invalid-event-number,omitempty"` // Index of the rejected event in the request
+	AckID              *int64          `json:"ackId,omitempty"`                // Set when indexer acknowledgement is enabled
+	Acks               map[string]bool `json:"acks,omitempty"`                 // Ack endpoint status, by ackId
 }
 
-// NewClient creates a new Splunk HEC client
-func NewClient(url, token string, options *Options) *Client {
+// NewClient creates a new Splunk HEC client. It returns an error if the TLS
+// or proxy options can't be used.
+func NewClient(url, token string, options *Options) (*Client, error) {
 	if options == nil {
 		options = &Options{
 			Timeout:   30 * time.Second,
@@ -65,42 +121,101 @@ func NewClient(url, token string, options *Options) *Client {
 		}
 	}
 
-	// Set up HTTP client with proper timeout and TLS configuration
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: options.InsecureSSL},
+	// Set up HTTP client with proper timeout, TLS and proxy configuration
+	transport, err := newTransport(options)
+	if err != nil {
+		return nil, err
 	}
 	httpClient := &http.Client{
 		Timeout:   options.Timeout,
 		Transport: transport,
 	}
 
+	// Indexer acknowledgement needs a channel to poll on
+	channel := options.Channel
+	if channel == "" && options.UseACK {
+		channel = NewChannel()
+	}
+	ackTimeout := options.AckTimeout
+	if ackTimeout <= 0 {
+		ackTimeout = DefaultAckTimeout
+	}
+	ackPollInterval := options.AckPollInterval
+	if ackPollInterval <= 0 {
+		ackPollInterval = DefaultAckPollInterval
+	}
+
+	endpoint := options.Endpoint
+	if endpoint == "" {
+		endpoint = EndpointEvent
+	}
+
+	balance := options.Balance
+	if balance == "" {
+		balance = BalanceRoundRobin
+	}
+	unhealthyAfter := options.UnhealthyAfter
+	if unhealthyAfter <= 0 {
+		unhealthyAfter = DefaultUnhealthyAfter
+	}
+	healthCheckInterval := options.HealthCheckInterval
+	if healthCheckInterval <= 0 {
+		healthCheckInterval = DefaultHealthCheckInterval
+	}
+
+	maxBatchBytes := options.MaxBatchBytes
+	if maxBatchBytes <= 0 {
+		maxBatchBytes = DefaultMaxBatchBytes
+	}
+
+	gzipLevel := options.GzipLevel
+	if gzipLevel == 0 || gzipLevel < gzip.HuffmanOnly || gzipLevel > gzip.BestCompression {
+		gzipLevel = gzip.DefaultCompression
+	}
+
 	return &Client{
 		URL:             url,
 		Token:           token,
 		InsecureSSL:     options.InsecureSSL,
 		Timeout:         options.Timeout,
 		BatchSize:       options.BatchSize,
+		MaxBatchBytes:   maxBatchBytes,
 		HTTPClient:      httpClient,
 		DefaultIndex:    options.DefaultIndex,
 		DefaultHost:     options.DefaultHost,
 		DefaultSource:   options.DefaultSource,
 		DefaultMetadata: options.DefaultMetadata,
 		Debug:           options.Debug,
-	}
+		Channel:         channel,
+		UseACK:          options.UseACK,
+		AckTimeout:      ackTimeout,
+		AckPollInterval: ackPollInterval,
+		Endpoint:        endpoint,
+		RawEventTime:    options.RawEventTime,
+		Gzip:            options.Gzip,
+		GzipLevel:       gzipLevel,
+
+		Balance:             balance,
+		UnhealthyAfter:      unhealthyAfter,
+		HealthCheckInterval: healthCheckInterval,
+		OnHealthChange:      options.OnHealthChange,
+		endpoints:           newEndpoints(append([]string{url}, options.URLs...)),
+	}, nil
 }
 
-// SendEvent sends a single event to Splunk HEC
-func (c *Client) SendEvent(event Event) error {
+// SendEvent sends a single event to Splunk HEC. Cancelling ctx stops the
+// request or the wait for its acknowledgement.
+func (c *Client) SendEvent(ctx context.Context, event Event) error {
+	e := c.pickEndpoint()
+	err := c.sendEvent(ctx, e, event)
+	c.finish(e, 1, err)
+	return err
+}
+
+// sendEvent sends a single event to one HEC URL
+func (c *Client) sendEvent(ctx context.Context, e *endpoint, event Event) error {
 	// Apply defaults if not set
-	if event.Index == "" {
-		event.Index = c.DefaultIndex
-	}
-	if event.Host == "" {
-		event.Host = c.DefaultHost
-	}
-	if event.Source == "" {
-		event.Source = c.DefaultSource
-	}
+	c.applyDefaults(&event)
 
 	if c.Debug {
 		log.Printf("DEBUG: Sending single event with sourcetype: %s", event.SourceType)
@@ -110,12 +225,31 @@ func (c *Client) SendEvent(event Event) error {
 	if err != nil {
 		return fmt.Errorf("error marshaling event: %w", err)
 	}
+	if len(payload) > c.MaxBatchBytes {
+		return &EventTooLargeError{Index: 0, Size: len(payload), Limit: c.MaxBatchBytes}
+	}
+
+	ackID, err := c.sendPayload(ctx, e, e.url, "application/json", payload)
+	if err != nil {
+		return err
+	}
+	return c.waitForAcks(ctx, e, ackIDs(ackID))
+}
 
-	return c.sendPayload(payload)
+// SendEvents sends multiple events to Splunk HEC. Cancelling ctx stops the
+// requests or the wait for their acknowledgement.
+func (c *Client) SendEvents(ctx context.Context, events []Event) error {
+	encoded, err := c.encodeAll(events)
+	if err != nil {
+		return err
+	}
+	return c.SendEncoded(ctx, encoded)
 }
 
-// SendEvents sends multiple events to Splunk HEC
-func (c *Client) SendEvents(events []Event) error {
+// SendEncoded sends events encoded by Encode to Splunk HEC. Cancelling ctx
+// stops the requests or the wait for their acknowledgement. A failure is a
+// *SendError naming the URL the events were sent to.
+func (c *Client) SendEncoded(ctx context.Context, events []EncodedEvent) error {
 	if len(events) == 0 {
 		return nil
 	}
@@ -124,52 +258,102 @@ func (c *Client) SendEvents(events []Event) error {
 		log.Printf("DEBUG: Preparing to send %d events", len(events))
 	}
 
-	// Apply defaults to each event
-	for i := range events {
-		if events[i].Index == "" {
-			events[i].Index = c.DefaultIndex
-		}
-		if events[i].Host == "" {
-			events[i].Host = c.DefaultHost
-		}
-		if events[i].Source == "" {
-			events[i].Source = c.DefaultSource
-		}
+	// Every request for these events goes to the same URL, which is also
+	// the one to poll for their acknowledgement
+	e := c.pickEndpoint()
+	err := c.sendEvents(ctx, e, events)
+	c.finish(e, len(events), err)
+	if err != nil {
+		return &SendError{URL: e.url, Err: err}
 	}
+	return nil
+}
 
-	// For smaller batches, send directly
-	if len(events) <= c.BatchSize {
-		payload, err := json.Marshal(events)
-		if err != nil {
-			return fmt.Errorf("error marshaling events: %w", err)
+// requestFailed completes a HEC error for a request of events[start:end]:
+// the rejected event's position is made relative to all of events, and the
+// error notes whether the events named their own index
+func (c *Client) requestFailed(err error, events []EncodedEvent, start, end int) {
+	var hecErr *Error
+	if !errors.As(err, &hecErr) {
+		return
+	}
+	if hecErr.InvalidEvent >= 0 {
+		hecErr.InvalidEvent += start
+	}
+	for _, event := range events[start:end] {
+		if event.Index != c.DefaultIndex {
+			hecErr.EventIndex = true
+			break
 		}
-		return c.sendPayload(payload)
 	}
+}
 
-	// For larger batches, split into smaller chunks
-	if c.Debug {
-		log.Printf("DEBUG: Splitting %d events into batches of %d", len(events), c.BatchSize)
+// sendEvents sends events to one HEC URL
+func (c *Client) sendEvents(ctx context.Context, e *endpoint, events []EncodedEvent) error {
+	// The raw endpoint batches by metadata instead
+	if c.Endpoint == EndpointRaw {
+		return c.sendRaw(ctx, e, events)
 	}
 
-	for i := 0; i < len(events); i += c.BatchSize {
-		end := i + c.BatchSize
-		if end > len(events) {
-			end = len(events)
+	// Send the events in requests limited by event count and by payload
+	// size, whichever is reached first
+	var pendingAcks []int64
+	var payload []byte
+	start := 0
+	send := func(end int) error {
+		if end == start {
+			return nil
 		}
-		batch := events[i:end]
+		payload[len(payload)-1] = ']' // In place of the last separator
 
 		if c.Debug {
-			log.Printf("DEBUG: Sending batch %d-%d of %d", i, end, len(events))
+			log.Printf("DEBUG: Sending batch %d-%d of %d (%d bytes)", start, end, len(events), len(payload))
 		}
 
-		payload, err := json.Marshal(batch)
+		ackID, err := c.sendPayload(ctx, e, e.url, "application/json", payload)
 		if err != nil {
-			return fmt.Errorf("error marshaling events batch %d-%d: %w", i, end, err)
+			c.requestFailed(err, events, start, end)
+			return fmt.Errorf("error sending events batch %d-%d: %w", start, end, err)
 		}
+		pendingAcks = append(pendingAcks, ackIDs(ackID)...)
 
-		if err := c.sendPayload(payload); err != nil {
-			return fmt.Errorf("error sending events batch %d-%d: %w", i, end, err)
+		// The payload may still be read by a gzip stream, so start a new one
+		payload, start = nil, end
+		return nil
+	}
+
+	for i, event := range events {
+		size := len(event.Payload)
+
+		// An event that can't fit in any request stops the batch; the
+		// events ahead of it are still delivered
+		if tooLargeErr := c.tooLarge(i, size); tooLargeErr != nil {
+			if err := send(i); err != nil {
+				return err
+			}
+			if err := c.waitForAcks(ctx, e, pendingAcks); err != nil {
+				return err
+			}
+			return tooLargeErr
+		}
+
+		if (c.BatchSize > 0 && i-start == c.BatchSize) || len(payload)+size > c.MaxBatchBytes {
+			if err := send(i); err != nil {
+				return err
+			}
 		}
+		if payload == nil {
+			payload = []byte{'['}
+		}
+		payload = append(payload, event.Payload...)
+	}
+	if err := send(len(events)); err != nil {
+		return err
+	}
+
+	// The events only count as delivered once indexed
+	if err := c.waitForAcks(ctx, e, pendingAcks); err != nil {
+		return err
 	}
 
 	if c.Debug {
@@ -179,25 +363,80 @@ func (c *Client) SendEvents(events []Event) error {
 	return nil
 }
 
-// sendPayload sends a JSON payload to Splunk HEC
-func (c *Client) sendPayload(payload []byte) error {
+// sendPayload sends a payload of events to a Splunk HEC endpoint. With
+// indexer acknowledgement, it returns the ID to poll for.
+func (c *Client) sendPayload(ctx context.Context, e *endpoint, url, contentType string, payload []byte) (*int64, error) {
 	if c.Debug {
-		log.Printf("DEBUG: Sending payload to %s (length: %d bytes)", c.URL, len(payload))
+		log.Printf("DEBUG: Sending payload to %s (length: %d bytes)", url, len(payload))
 		// Print the first part of the payload for debugging (limit to avoid flooding logs)
 		if len(payload) < 1000 {
-			log.Printf("DEBUG: Payload: %s", string(payload))
+			log.Printf("DEBUG: Payload: %s", c.redact(string(payload)))
 		} else {
-			log.Printf("DEBUG: Payload (truncated): %s...", string(payload[:1000]))
+			log.Printf("DEBUG: Payload (truncated): %s...", c.redact(string(payload[:1000])))
+		}
+	}
+
+	// Compress as the request is written rather than into another buffer.
+	// An uncompressed payload is sent as a *bytes.Reader, so the request
+	// has a Content-Length rather than being chunked.
+	var body io.Reader = bytes.NewReader(payload)
+	var compressed *countingReader
+	encoding := ""
+	if c.Gzip {
+		compressed = &countingReader{r: c.gzipStream(payload)}
+		body = compressed
+		encoding = "gzip"
+	}
+
+	started := time.Now()
+	hecResponse, err := c.post(ctx, url, contentType, encoding, body)
+	e.observe(len(payload), time.Since(started), err)
+	c.rawBytes.Add(int64(len(payload)))
+	if compressed != nil {
+		c.wireBytes.Add(compressed.n.Load())
+	} else {
+		c.wireBytes.Add(int64(len(payload)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.UseACK && hecResponse.AckID == nil {
+		return nil, &Error{
+			StatusCode:   http.StatusOK,
+			Code:         CodeACKDisabled,
+			Text:         "HEC did not return an ackId; indexer acknowledgement is not enabled for this token",
+			InvalidEvent: -1,
 		}
 	}
 
-	req, err := http.NewRequest("POST", c.URL, bytes.NewBuffer(payload))
+	if c.Debug {
+		log.Printf("DEBUG: Successfully sent payload")
+	}
+
+	return hecResponse.AckID, nil
+}
+
+// post sends a request body to a HEC endpoint and returns its response,
+// turning HEC error responses into *Error. A body that is an io.Closer is
+// closed once sent; a *bytes.Reader body is sent with a Content-Length.
+func (c *Client) post(ctx context.Context, url, contentType, contentEncoding string, reqBody io.Reader) (Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, reqBody)
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		if closer, ok := reqBody.(io.Closer); ok {
+			closer.Close()
+		}
+		return Response{}, fmt.Errorf("error creating request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
 	req.Header.Set("Authorization", "Splunk "+c.Token)
+	if c.Channel != "" {
+		req.Header.Set("X-Splunk-Request-Channel", c.Channel)
+	}
 
 	// Add any custom headers from DefaultMetadata
 	for key, value := range c.DefaultMetadata {
@@ -205,23 +444,23 @@ func (c *Client) sendPayload(payload []byte) error {
 	}
 
 	if c.Debug {
-		log.Printf("DEBUG: Request headers: %v", req.Header)
+		log.Printf("DEBUG: Request headers: %v", redactHeaders(req.Header))
 	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending request: %w", err)
+		return Response{}, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
+		return Response{}, fmt.Errorf("error reading response body: %w", err)
 	}
 
 	if c.Debug {
 		log.Printf("DEBUG: Response status: %s", resp.Status)
-		log.Printf("DEBUG: Response body: %s", string(body))
+		log.Printf("DEBUG: Response body: %s", c.redact(string(body)))
 	}
 
 	var hecResponse Response
@@ -229,41 +468,60 @@ func (c *Client) sendPayload(payload []byte) error {
 		if c.Debug {
 			log.Printf("DEBUG: Failed to parse response as JSON: %v", err)
 		}
-		return fmt.Errorf("error parsing response: %w", err)
-	}
-
-	if hecResponse.Code != 0 {
-		return fmt.Errorf("HEC error: %s (code: %d)", hecResponse.Text, hecResponse.Code)
+		if resp.StatusCode >= 300 {
+			// Not a HEC response (e.g. from a proxy); classify by HTTP status
+			return Response{}, &Error{
+				StatusCode:   resp.StatusCode,
+				Code:         -1,
+				Text:         truncate(c.redact(string(body)), 200),
+				RetryAfter:   parseRetryAfter(resp.Header.Get("Retry-After")),
+				InvalidEvent: -1,
+			}
+		}
+		return Response{}, fmt.Errorf("error parsing response: %w", err)
 	}
 
-	if c.Debug {
-		log.Printf("DEBUG: Successfully sent payload")
+	if hecResponse.Code != CodeSuccess || resp.StatusCode >= 300 {
+		hecErr := &Error{
+			StatusCode:   resp.StatusCode,
+			Code:         hecResponse.Code,
+			Text:         c.redact(hecResponse.Text),
+			RetryAfter:   parseRetryAfter(resp.Header.Get("Retry-After")),
+			InvalidEvent: -1,
+		}
+		if hecResponse.InvalidEventNumber != nil {
+			hecErr.InvalidEvent = *hecResponse.InvalidEventNumber
+		}
+		return Response{}, hecErr
 	}
 
-	return nil
+	return hecResponse, nil
 }
 
-// HealthCheck validates connectivity to the HEC endpoint
-func (c *Client) HealthCheck() error {
-	// Send a minimal event to check connectivity
-	testEvent := Event{
-		Event: map[string]interface{}{
-			"message": "HEC connection test from spexma",
-		},
-		SourceType: "spexma:test",
+// truncate shortens s to at most n bytes for error messages
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
 	}
+	return s[:n] + "..."
+}
 
-	if c.Debug {
-		log.Println("DEBUG: Performing HEC health check")
-	}
+// redacted stands in for the token in logs and error messages
+const redacted = "REDACTED"
 
-	if err := c.SendEvent(testEvent); err != nil {
-		return fmt.Errorf("HEC health check failed: %w", err)
+// redact replaces the client's token in text bound for logs or error messages
+func (c *Client) redact(s string) string {
+	if c.Token == "" {
+		return s
 	}
+	return strings.ReplaceAll(s, c.Token, redacted)
+}
 
-	if c.Debug {
-		log.Println("DEBUG: HEC health check passed successfully")
+// redactHeaders returns a copy of request headers safe to log
+func redactHeaders(header http.Header) http.Header {
+	header = header.Clone()
+	if header.Get("Authorization") != "" {
+		header.Set("Authorization", "Splunk "+redacted)
 	}
-
-	return nil
+	return header
 }
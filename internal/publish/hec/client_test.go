@@ -0,0 +1,70 @@
+package hec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestTokenRedacted checks that the token never reaches debug logs or
+// errors, even when HEC echoes it back
+func TestTokenRedacted(t *testing.T) {
+	const token = "0b5c7fe4-c625-381e-fa0b-d572962aa0fd"
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{
+			name:   "HEC error",
+			status: http.StatusForbidden,
+			body:   `{"text":"Invalid token ` + token + `","code":4}`,
+		},
+		{
+			name:   "proxy error",
+			status: http.StatusBadGateway,
+			body:   "<html>upstream rejected Splunk " + token + "</html>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			var logs bytes.Buffer
+			stderr := log.Writer()
+			log.SetOutput(&logs)
+			defer log.SetOutput(stderr)
+
+			client, err := NewClient(server.URL+"/services/collector/event", token, &Options{Debug: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = client.SendEvent(context.Background(), Event{Event: map[string]any{"message": "token " + token}})
+			if err == nil {
+				t.Fatal("got no error, want HEC's")
+			}
+
+			for name, text := range map[string]string{"error": err.Error(), "debug log": logs.String()} {
+				if strings.Contains(text, token) {
+					t.Errorf("%s holds the token: %s", name, text)
+				}
+				if !strings.Contains(text, redacted) {
+					t.Errorf("%s doesn't show where the token was redacted: %s", name, text)
+				}
+			}
+			if !strings.Contains(logs.String(), "Splunk "+redacted) {
+				t.Errorf("debug log doesn't show the redacted Authorization header: %s", logs.String())
+			}
+		})
+	}
+}
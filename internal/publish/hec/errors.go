@@ -0,0 +1,197 @@
+package hec
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HEC status codes, from the Splunk HTTP Event Collector documentation
+const (
+	CodeSuccess               = 0
+	CodeTokenDisabled         = 1
+	CodeTokenRequired         = 2
+	CodeInvalidAuthorization  = 3
+	CodeInvalidToken          = 4
+	CodeNoData                = 5
+	CodeInvalidDataFormat     = 6
+	CodeIncorrectIndex        = 7
+	CodeInternalServerError   = 8
+	CodeServerBusy            = 9
+	CodeDataChannelMissing    = 10
+	CodeInvalidDataChannel    = 11
+	CodeEventFieldRequired    = 12
+	CodeEventFieldBlank       = 13
+	CodeACKDisabled           = 14
+	CodeIndexedFieldsError    = 15
+	CodeQueryStringAuthOff    = 16
+	CodeUnhealthyQueuesFull   = 18
+	CodeUnhealthyACKService   = 19
+	CodeUnhealthyQueuesAndACK = 20
+)
+
+// Class describes whether a failed request is worth retrying
+type Class int
+
+const (
+	// ClassRetryable errors are transient: the same request may succeed later
+	ClassRetryable Class = iota
+	// ClassRejected errors mean HEC rejected the request's data; resending
+	// the same payload will fail again, but other payloads may succeed
+	ClassRejected
+	// ClassFatal errors are configuration problems (token, index, channel)
+	// that no request will get past
+	ClassFatal
+)
+
+// String returns the name of the class
+func (c Class) String() string {
+	switch c {
+	case ClassRetryable:
+		return "retryable"
+	case ClassRejected:
+		return "rejected"
+	default:
+		return "fatal"
+	}
+}
+
+// Error is an error response from HEC
+type Error struct {
+	StatusCode int           // HTTP status code
+	Code       int           // HEC status code, or -1 if the body wasn't a HEC response
+	Text       string        // HEC status text or raw response body
+	RetryAfter time.Duration // Delay requested by a Retry-After header, if any
+
+	// InvalidEvent is the index of the event HEC rejected, or -1 if it didn't
+	// say. HEC indexes the events ahead of the invalid one in the request.
+	InvalidEvent int
+
+	// EventIndex is set when the request's events named their own index
+	// rather than taking the client's default
+	EventIndex bool
+}
+
+// Error returns a description of the HEC error
+func (e *Error) Error() string {
+	if e.Code < 0 {
+		return fmt.Sprintf("HEC error: HTTP %d: %s", e.StatusCode, e.Text)
+	}
+	return fmt.Sprintf("HEC error: %s (code: %d)", e.Text, e.Code)
+}
+
+// Class classifies the error by HEC status code, falling back to the HTTP
+// status. An incorrect index is only fatal when it's the client's default:
+// an index named by the events themselves may be wrong for just some.
+func (e *Error) Class() Class {
+	switch e.Code {
+	case CodeIncorrectIndex:
+		if e.EventIndex {
+			return ClassRejected
+		}
+		return ClassFatal
+	case CodeTokenDisabled, CodeTokenRequired, CodeInvalidAuthorization, CodeInvalidToken,
+		CodeDataChannelMissing, CodeInvalidDataChannel, CodeACKDisabled, CodeQueryStringAuthOff:
+		return ClassFatal
+	case CodeNoData, CodeInvalidDataFormat, CodeEventFieldRequired, CodeEventFieldBlank,
+		CodeIndexedFieldsError:
+		return ClassRejected
+	case CodeInternalServerError, CodeServerBusy, CodeUnhealthyQueuesFull, CodeUnhealthyACKService,
+		CodeUnhealthyQueuesAndACK:
+		return ClassRetryable
+	}
+
+	switch {
+	case e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden,
+		e.StatusCode == http.StatusNotFound:
+		return ClassFatal
+	case e.StatusCode == http.StatusTooManyRequests, e.StatusCode == http.StatusRequestTimeout,
+		e.StatusCode >= 500:
+		return ClassRetryable
+	case e.StatusCode >= 400:
+		return ClassRejected
+	}
+	return ClassRetryable
+}
+
+// Classify returns the class of an error returned by the client. Errors that
+// aren't HEC responses, such as network failures, are treated as retryable.
+// An event too large to send is rejected like one HEC refuses.
+func Classify(err error) Class {
+	var hecErr *Error
+	if errors.As(err, &hecErr) {
+		return hecErr.Class()
+	}
+	if IsEventTooLarge(err) {
+		return ClassRejected
+	}
+	return ClassRetryable
+}
+
+// RetryAfter returns the delay HEC asked for before retrying, or zero
+func RetryAfter(err error) time.Duration {
+	var hecErr *Error
+	if errors.As(err, &hecErr) {
+		return hecErr.RetryAfter
+	}
+	return 0
+}
+
+// SendError is a failed send of events, recording which of the client's
+// URLs the balancer sent them to
+type SendError struct {
+	URL string
+	Err error
+}
+
+// Error returns the underlying error's description
+func (e *SendError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// FailedURL returns the HEC URL a failed send was made to, or "" if err
+// doesn't record one
+func FailedURL(err error) string {
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.URL
+	}
+	return ""
+}
+
+// InvalidEventIndex returns the index of the event HEC rejected, if it
+// reported one, or of the event too large to send
+func InvalidEventIndex(err error) (int, bool) {
+	var hecErr *Error
+	if errors.As(err, &hecErr) && hecErr.InvalidEvent >= 0 {
+		return hecErr.InvalidEvent, true
+	}
+	var tooLargeErr *EventTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		return tooLargeErr.Index, true
+	}
+	return 0, false
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
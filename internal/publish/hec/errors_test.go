@@ -0,0 +1,87 @@
+package hec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{"network failure", errors.New("connection refused"), ClassRetryable},
+		{"invalid token", &Error{StatusCode: 403, Code: CodeInvalidToken}, ClassFatal},
+		{"server busy", &Error{StatusCode: 503, Code: CodeServerBusy}, ClassRetryable},
+		{"invalid data format", &Error{StatusCode: 400, Code: CodeInvalidDataFormat}, ClassRejected},
+		{"indexed fields error", &Error{StatusCode: 400, Code: CodeIndexedFieldsError}, ClassRejected},
+		{"incorrect default index", &Error{StatusCode: 400, Code: CodeIncorrectIndex}, ClassFatal},
+		{"incorrect event index", &Error{StatusCode: 400, Code: CodeIncorrectIndex, EventIndex: true}, ClassRejected},
+		{"not a HEC response, 401", &Error{StatusCode: 401, Code: -1}, ClassFatal},
+		{"not a HEC response, 429", &Error{StatusCode: 429, Code: -1}, ClassRetryable},
+		{"not a HEC response, 502", &Error{StatusCode: 502, Code: -1}, ClassRetryable},
+		{"not a HEC response, 413", &Error{StatusCode: 413, Code: -1}, ClassRejected},
+		{"event too large", &EventTooLargeError{Size: 10, Limit: 5}, ClassRejected},
+		{"wrapped", &SendError{URL: "u", Err: fmt.Errorf("batch: %w", &Error{Code: CodeTokenDisabled})}, ClassFatal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIncorrectIndex checks that HEC refusing an index stops a run only when
+// the index is the client's default
+func TestIncorrectIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"text":"Incorrect index","code":7,"invalid-event-number":1}`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		defaultIndex string
+		indexes      []string
+		want         Class
+	}{
+		{"client default", "missing", []string{"", ""}, ClassFatal},
+		{"token default", "", []string{"", ""}, ClassFatal},
+		{"events' own index", "main", []string{"main", "missing"}, ClassRejected},
+		{"events' own index without a default", "", []string{"main", "missing"}, ClassRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(server.URL+"/services/collector/event", "secret", &Options{
+				DefaultIndex: tt.defaultIndex,
+				Timeout:      time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var events []Event
+			for _, index := range tt.indexes {
+				events = append(events, Event{Index: index, Event: map[string]any{"message": "hello"}})
+			}
+
+			err = client.SendEvents(context.Background(), events)
+			if got := Classify(err); got != tt.want {
+				t.Errorf("got %s error %v, want %s", got, err, tt.want)
+			}
+			if index, ok := InvalidEventIndex(err); !ok || index != 1 {
+				t.Errorf("got invalid event %d, want 1", index)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package hec
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// fieldNameRGX matches the names HEC accepts for indexed fields. Names
+// starting with an underscore are reserved for Splunk's internal fields.
+var fieldNameRGX = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// reservedFields are default fields an indexed field can't be named after
+var reservedFields = map[string]bool{
+	"host":          true,
+	"source":        true,
+	"sourcetype":    true,
+	"index":         true,
+	"time":          true,
+	"linecount":     true,
+	"punct":         true,
+	"splunk_server": true,
+	"eventtype":     true,
+	"tag":           true,
+	"timestartpos":  true,
+	"timeendpos":    true,
+}
+
+// ValidateFieldName checks that name can be used for an indexed field
+func ValidateFieldName(name string) error {
+	if !fieldNameRGX.MatchString(name) {
+		return fmt.Errorf("invalid indexed field name '%s': it must start with a letter and contain only letters, digits and underscores", name)
+	}
+	if reservedFields[name] {
+		return fmt.Errorf("invalid indexed field name '%s': it is a default field", name)
+	}
+	return nil
+}
+
+// ValidateFields checks indexed fields against HEC's rules: valid names, and
+// values that are strings or lists of strings, as HEC rejects nested objects
+func ValidateFields(fields map[string]any) error {
+	for name, value := range fields {
+		if err := ValidateFieldName(name); err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case string, []string:
+		case []any:
+			for _, item := range v {
+				if _, ok := item.(string); !ok {
+					return fmt.Errorf("invalid value of indexed field '%s': a list must only hold strings", name)
+				}
+			}
+		default:
+			return fmt.Errorf("invalid value of indexed field '%s': it must be a string or a list of strings", name)
+		}
+	}
+	return nil
+}
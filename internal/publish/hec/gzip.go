@@ -0,0 +1,54 @@
+package hec
+
+import (
+	"compress/gzip"
+	"io"
+	"sync/atomic"
+)
+
+// gzipStream returns a reader of the gzip-compressed payload. The payload is
+// compressed by a goroutine as the request reads it, so the compressed body
+// is never held in memory as a whole. Closing the reader early stops the
+// goroutine.
+func (c *Client) gzipStream(payload []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		zw, ok := c.gzipWriters.Get().(*gzip.Writer)
+		if ok {
+			zw.Reset(pw)
+		} else {
+			// The level was checked by NewClient
+			zw, _ = gzip.NewWriterLevel(pw, c.GzipLevel)
+		}
+
+		_, err := zw.Write(payload)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+		c.gzipWriters.Put(zw)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// ByteCounts returns the number of event payload bytes sent so far, before
+// and after compression. The two are the same when compression is off.
+func (c *Client) ByteCounts() (raw, wire int64) {
+	return c.rawBytes.Load(), c.wireBytes.Load()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.ReadCloser
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.r.Close()
+}
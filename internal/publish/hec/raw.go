@@ -0,0 +1,142 @@
+package hec
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HEC endpoints events can be sent to
+const (
+	EndpointEvent = "event" // JSON events on /services/collector
+	EndpointRaw   = "raw"   // Original event text on /services/collector/raw
+)
+
+// RawField is the event field holding the original event text sent to the raw endpoint
+const RawField = "_raw"
+
+// rawKey is the metadata shared by the events of one raw endpoint request
+type rawKey struct {
+	host, source, sourcetype, index string
+	time                            int64
+}
+
+// sendRaw sends events to the raw endpoint. The raw endpoint takes metadata
+// per request rather than per event, so runs of events that share their
+// host, source, sourcetype and index (and time, with RawEventTime) are sent
+// together, one event per line, up to a batch's count and size limits.
+// Events are never regrouped out of order: callers rely on the events ahead
+// of a rejected one having been sent, so with RawEventTime every change of
+// time starts a new request.
+func (c *Client) sendRaw(ctx context.Context, e *endpoint, events []EncodedEvent) error {
+	var pendingAcks []int64
+	for start := 0; start < len(events); {
+		// An event that can't fit in any request stops the batch; the
+		// events ahead of it are still delivered
+		if tooLargeErr := c.tooLarge(start, len(events[start].Payload)); tooLargeErr != nil {
+			if err := c.waitForAcks(ctx, e, pendingAcks); err != nil {
+				return err
+			}
+			return tooLargeErr
+		}
+
+		// Gather the run of events sharing metadata, up to a batch
+		key := c.rawKey(events[start].Event)
+		size := len(events[start].Payload)
+		end := start + 1
+		for end < len(events) && end-start < c.BatchSize && c.rawKey(events[end].Event) == key &&
+			size+len(events[end].Payload) <= c.MaxBatchBytes {
+			size += len(events[end].Payload)
+			end++
+		}
+
+		rawURL, err := c.rawURL(e.url, key)
+		if err != nil {
+			return err
+		}
+
+		payload := make([]byte, 0, size)
+		for _, event := range events[start:end] {
+			payload = append(payload, event.Payload...)
+		}
+
+		if c.Debug {
+			log.Printf("DEBUG: Sending raw events %d-%d of %d (sourcetype: %s, %d bytes)", start, end, len(events), key.sourcetype, size)
+		}
+
+		ackID, err := c.sendPayload(ctx, e, rawURL, "text/plain", payload)
+		if err != nil {
+			c.requestFailed(err, events, start, end)
+			return fmt.Errorf("error sending raw events %d-%d: %w", start, end, err)
+		}
+		pendingAcks = append(pendingAcks, ackIDs(ackID)...)
+		start = end
+	}
+
+	// The events only count as delivered once indexed
+	return c.waitForAcks(ctx, e, pendingAcks)
+}
+
+// rawKey returns the request metadata of an event
+func (c *Client) rawKey(event Event) rawKey {
+	key := rawKey{
+		host:       event.Host,
+		source:     event.Source,
+		sourcetype: event.SourceType,
+		index:      event.Index,
+	}
+	if c.RawEventTime && event.Time != nil {
+		key.time = *event.Time
+	}
+	return key
+}
+
+// rawURL returns the raw endpoint URL next to baseURL carrying a request's metadata
+func (c *Client) rawURL(baseURL string, key rawKey) (string, error) {
+	rawURL, err := c.endpointURL(baseURL, "raw")
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	if key.host != "" {
+		query.Set("host", key.host)
+	}
+	if key.source != "" {
+		query.Set("source", key.source)
+	}
+	if key.sourcetype != "" {
+		query.Set("sourcetype", key.sourcetype)
+	}
+	if key.index != "" {
+		query.Set("index", key.index)
+	}
+	if key.time != 0 {
+		query.Set("time", strconv.FormatInt(key.time, 10))
+	}
+	if len(query) == 0 {
+		return rawURL, nil
+	}
+	return rawURL + "?" + query.Encode(), nil
+}
+
+// endpointURL returns the collector endpoint with the given name (such as
+// "raw" or "ack") next to the collector endpoint in baseURL
+func (c *Client) endpointURL(baseURL, name string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing HEC URL: %w", err)
+	}
+
+	// Replace /services/collector[/event|/raw|...] with /services/collector/<name>
+	if i := strings.Index(u.Path, "/services/collector"); i >= 0 {
+		u.Path = u.Path[:i] + "/services/collector/" + name
+	} else {
+		u.Path = "/services/collector/" + name
+	}
+	u.RawQuery = ""
+	return u.String(), nil
+}
@@ -0,0 +1,53 @@
+package hec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestSendRawEventTime checks that with RawEventTime only consecutive events
+// with the same time share a request, and that events keep their order
+func TestSendRawEventTime(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string // time parameter and body of each request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r.URL.Query().Get("time")+":"+strings.ReplaceAll(strings.TrimSpace(string(body)), "\n", ","))
+		mu.Unlock()
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL+"/services/collector", "secret", &Options{
+		Endpoint:     EndpointRaw,
+		RawEventTime: true,
+		BatchSize:    100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	for i, ts := range []int64{100, 100, 101, 100, 101, 101} {
+		events = append(events, Event{
+			Time:       &ts,
+			SourceType: "syslog",
+			Event:      map[string]any{RawField: fmt.Sprintf("line %d", i)},
+		})
+	}
+	if err := client.SendEvents(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"100:line 0,line 1", "101:line 2", "100:line 3", "101:line 4,line 5"}
+	if fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("got requests %q, want %q", requests, want)
+	}
+}
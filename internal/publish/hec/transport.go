@@ -0,0 +1,101 @@
+package hec
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// tlsVersions are the names MinTLSVersion accepts
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTransport returns the HTTP transport for the client's TLS and proxy
+// options. It keeps Go's default dialer, HTTP/2 and idle timeouts, with
+// enough idle connections per URL for every concurrent request to reuse one.
+func newTransport(options *Options) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without an explicit proxy, HTTPS_PROXY, HTTP_PROXY and NO_PROXY apply
+	proxy := http.ProxyFromEnvironment
+	if options.ProxyURL != "" {
+		proxyURL, err := url.Parse(options.ProxyURL)
+		if err != nil {
+			// The parse error quotes the URL, which may hold credentials
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid proxy URL %s (expected an http, https or socks5 URL)", proxyURL.Redacted())
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConnsPerHost = max(options.Concurrency, http.DefaultMaxIdleConnsPerHost)
+	transport.MaxIdleConns = max(transport.MaxIdleConns, transport.MaxIdleConnsPerHost*(1+len(options.URLs)))
+	return transport, nil
+}
+
+// newTLSConfig returns the TLS configuration for the client's options
+func newTLSConfig(options *Options) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: options.InsecureSSL,
+		ServerName:         options.ServerName,
+	}
+
+	if options.MinTLSVersion != "" {
+		version, ok := tlsVersions[options.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid minimum TLS version '%s' (expected 1.0, 1.1, 1.2 or 1.3)", options.MinTLSVersion)
+		}
+		config.MinVersion = version
+	}
+
+	// Trust the CA bundle as well as the system's CAs
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (options.ClientCertFile == "") != (options.ClientKeyFile == "") {
+		return nil, errors.New("a client certificate needs both a certificate and a key file")
+	}
+	if options.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
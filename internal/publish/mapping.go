@@ -0,0 +1,74 @@
+package publish
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFieldMappings reads field renames from a mapping file. A .yaml or .yml
+// file maps old names to new ones; any other file is a CSV of old,new pairs,
+// where lines starting with # are comments.
+func LoadFieldMappings(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mapping file: %w", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAMLMappings(file, path)
+	default:
+		return parseCSVMappings(file, path)
+	}
+}
+
+// parseYAMLMappings reads a YAML mapping of old field names to new ones
+func parseYAMLMappings(r io.Reader, path string) (map[string]string, error) {
+	mappings := make(map[string]string)
+	if err := yaml.NewDecoder(r).Decode(&mappings); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing mapping file %s: %w", path, err)
+	}
+	for old, name := range mappings {
+		if old == "" || name == "" {
+			return nil, fmt.Errorf("mapping file %s maps '%s' to '%s'; both names are needed", path, old, name)
+		}
+	}
+	return mappings, nil
+}
+
+// parseCSVMappings reads old,new pairs from a CSV
+func parseCSVMappings(r io.Reader, path string) (map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	mappings := make(map[string]string)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return mappings, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing mapping file %s: %w", path, err)
+		}
+
+		old, name := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		line, _ := reader.FieldPos(0)
+		if old == "" || name == "" {
+			return nil, fmt.Errorf("mapping file %s line %d: both the old and new field names are needed", path, line)
+		}
+		if _, ok := mappings[old]; ok {
+			return nil, fmt.Errorf("mapping file %s line %d: '%s' is mapped more than once", path, line, old)
+		}
+		mappings[old] = name
+	}
+}
@@ -0,0 +1,98 @@
+package publish
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadFieldMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "CSV with comments",
+			file:    "mappings.csv",
+			content: "# old,new\nsrc, src_ip\n\n\"dest, port\",dest_port\n",
+			want:    "map[dest, port:dest_port src:src_ip]",
+		},
+		{
+			name:    "YAML",
+			file:    "mappings.yaml",
+			content: "src: src_ip\ndst: dest_ip\n",
+			want:    "map[dst:dest_ip src:src_ip]",
+		},
+		{
+			name:    "empty YAML",
+			file:    "mappings.yml",
+			content: "",
+			want:    "map[]",
+		},
+		{
+			name:    "CSV row without a new name",
+			file:    "mappings.csv",
+			content: "src,src_ip\ndst,\n",
+			wantErr: "line 2: both the old and new field names are needed",
+		},
+		{
+			name:    "CSV row with too many fields",
+			file:    "mappings.csv",
+			content: "src,src_ip,extra\n",
+			wantErr: "wrong number of fields",
+		},
+		{
+			name:    "CSV field mapped twice",
+			file:    "mappings.csv",
+			content: "src,src_ip\nsrc,source\n",
+			wantErr: "line 2: 'src' is mapped more than once",
+		},
+		{
+			name:    "YAML without a new name",
+			file:    "mappings.yaml",
+			content: "src: ''\n",
+			wantErr: "maps 'src' to ''; both names are needed",
+		},
+		{
+			name:    "YAML that isn't a map",
+			file:    "mappings.yaml",
+			content: "- src\n- dst\n",
+			wantErr: "error parsing mapping file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			mappings, err := LoadFieldMappings(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(mappings); got != tt.want {
+				t.Errorf("got mappings %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFieldMappingsMissingFile(t *testing.T) {
+	_, err := LoadFieldMappings(filepath.Join(t.TempDir(), "mappings.csv"))
+	if err == nil || !strings.Contains(err.Error(), "error opening mapping file") {
+		t.Errorf("got error %v, want the file to be missing", err)
+	}
+}
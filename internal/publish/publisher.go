@@ -1,11 +1,15 @@
 package publish
 
 import (
-	"encoding/json"
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -26,18 +30,18 @@ type Progress struct {
 	ElapsedTime      time.Duration
 	StartTime        time.Time
 	EstimatedEndTime time.Time
+	limiter          *RateLimiter
 }
 
-// UpdateProgress updates the progress information
-func (p *Progress) UpdateProgress(processedFiles, totalEvents, publishedEvents, failedEvents int, currentFile string) {
+// AddEvents adds to the event counters. Callers pass deltas rather than
+// new totals so that concurrent workers never overwrite each other's counts.
+func (p *Progress) AddEvents(total, published, failed int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.ProcessedFiles = processedFiles
-	p.TotalEvents = totalEvents
-	p.PublishedEvents = publishedEvents
-	p.FailedEvents = failedEvents
-	p.CurrentFile = currentFile
+	p.TotalEvents += total
+	p.PublishedEvents += published
+	p.FailedEvents += failed
 	p.ElapsedTime = time.Since(p.StartTime)
 
 	// Calculate estimated end time
@@ -51,6 +55,35 @@ func (p *Progress) UpdateProgress(processedFiles, totalEvents, publishedEvents,
 	}
 }
 
+// FileCompleted counts a file as processed
+func (p *Progress) FileCompleted() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ProcessedFiles++
+	p.ElapsedTime = time.Since(p.StartTime)
+}
+
+// SetTotalFiles sets the number of files to process
+func (p *Progress) SetTotalFiles(totalFiles int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.TotalFiles = totalFiles
+}
+
+// SetCurrentFile sets the file most recently being worked on
+func (p *Progress) SetCurrentFile(currentFile string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.CurrentFile = currentFile
+}
+
+// GetFailedEvents returns the number of events that could not be published
+func (p *Progress) GetFailedEvents() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.FailedEvents
+}
+
 // SetStatus updates the status message
 func (p *Progress) SetStatus(status string) {
 	p.mu.Lock()
@@ -65,18 +98,55 @@ func (p *Progress) GetStats() (int, int, int, int, string, time.Duration, string
 	return p.ProcessedFiles, p.TotalFiles, p.PublishedEvents, p.TotalEvents, p.CurrentFile, p.ElapsedTime, p.Status
 }
 
+// RateStats returns the measured rate of each rate limit, or nil if the run isn't rate limited
+func (p *Progress) RateStats() []RateStat {
+	if p.limiter == nil {
+		return nil
+	}
+	return p.limiter.Stats()
+}
+
+// FileResult records the outcome of publishing a single file
+type FileResult struct {
+	File            string
+	SourceType      string
+	TotalEvents     int
+	PublishedEvents int
+	FailedEvents    int
+	Duration        time.Duration
+	Err             error
+	Skipped         bool // Already published by the run being resumed
+	ResumedAt       int  // Data rows skipped because the run being resumed published them
+}
+
+// RejectedEvent is an event HEC refused to accept, set aside so the rest of its batch could be sent
+type RejectedEvent struct {
+	File  string
+	Line  int // Line in the CSV file where the event's record starts
+	Event hec.Event
+	Err   error
+}
+
 // PublisherConfig contains configuration for the publisher
 type PublisherConfig struct {
 	HECClient       *hec.Client
 	Transformer     *Transformer
 	Concurrency     int
 	BatchSize       int
+	BatchBytes      int // Limit on the payload size of a batch, as measured by HECClient
 	RetryCount      int
-	RetryWait       time.Duration
+	RetryWait       time.Duration // Delay before the first retry; later retries back off exponentially
+	RetryMaxWait    time.Duration // Cap on the delay between retries
+	RetryMaxElapsed time.Duration // Give up on a batch after this long, if set
 	OutputDirectory string
 	ProgressCh      chan<- *Progress
 	DryRun          bool
 	Debug           bool
+	PreserveOrder   bool              // If true, each file has at most one batch in flight so its events arrive in order
+	DeadLetter      *DeadLetterWriter // Receives every event that fails to publish, if set
+	ReplayIndex     string            // Index to send replayed events to, instead of their original one
+	Checkpoints     *Checkpointer     // Records how far each file has been published, if set
+	RateLimiter     *RateLimiter      // Shared by all senders to stay under ingest rate limits, if set
 }
 
 // Publisher handles the publishing of events to Splunk HEC
@@ -85,7 +155,17 @@ type Publisher struct {
 	progress  *Progress
 	errorChan chan error
 	wg        sync.WaitGroup
+	senderWg  sync.WaitGroup
 	stopCh    chan struct{}
+	resultsMu sync.Mutex
+	results   []FileResult
+	cancel    context.CancelFunc
+	fatalOnce sync.Once
+	fatalMu   sync.Mutex
+	fatalErr  error
+
+	rejectedMu sync.Mutex
+	rejected   []RejectedEvent
 }
 
 // NewPublisher creates a new publisher
@@ -96,23 +176,33 @@ func NewPublisher(config *PublisherConfig) *Publisher {
 	if config.RetryWait <= 0 {
 		config.RetryWait = 2 * time.Second
 	}
+	if config.RetryMaxWait < config.RetryWait {
+		config.RetryMaxWait = 30 * time.Second
+		if config.RetryMaxWait < config.RetryWait {
+			config.RetryMaxWait = config.RetryWait
+		}
+	}
 	if config.Concurrency <= 0 {
 		config.Concurrency = 4
 	}
 	if config.BatchSize <= 0 {
 		config.BatchSize = 100
 	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = hec.DefaultMaxBatchBytes
+	}
 
 	return &Publisher{
 		config:    config,
-		progress:  &Progress{StartTime: time.Now()},
+		progress:  &Progress{StartTime: time.Now(), limiter: config.RateLimiter},
 		errorChan: make(chan error, 100),
 		stopCh:    make(chan struct{}),
 	}
 }
 
-// PublishDirectory processes all CSV files in a directory and publishes events to Splunk HEC
-func (p *Publisher) PublishDirectory(directory string) error {
+// PublishDirectory processes all CSV files in a directory and publishes events to Splunk HEC.
+// Cancelling ctx stops the workers after their current batch.
+func (p *Publisher) PublishDirectory(ctx context.Context, directory string) error {
 	// Get all CSV files in the directory
 	files, err := filepath.Glob(filepath.Join(directory, "*.csv"))
 	if err != nil {
@@ -127,17 +217,24 @@ func (p *Publisher) PublishDirectory(directory string) error {
 		log.Printf("DEBUG: Found %d CSV files to process in %s", len(files), directory)
 	}
 
+	// Cancel everything in flight if a fatal error is hit
+	ctx, p.cancel = context.WithCancel(ctx)
+	defer p.cancel()
+
 	// Update progress
-	p.progress.TotalFiles = len(files)
+	p.progress.SetTotalFiles(len(files))
 	p.progress.SetStatus("Starting")
 
 	// Make a channel for file paths
 	filesCh := make(chan string, len(files))
 
-	// Start worker goroutines
+	// Start the sender pool
+	batchCh := p.startSenders(ctx)
+
+	// Start reader goroutines that turn files into batches
 	for i := 0; i < p.config.Concurrency; i++ {
 		p.wg.Add(1)
-		go p.worker(filesCh)
+		go p.worker(ctx, filesCh, batchCh)
 	}
 
 	// Feed file paths to workers
@@ -146,8 +243,32 @@ func (p *Publisher) PublishDirectory(directory string) error {
 	}
 	close(filesCh)
 
-	// Wait for all workers to finish
+	// Wait for all readers to finish, then drain the senders
 	p.wg.Wait()
+	close(batchCh)
+	p.senderWg.Wait()
+
+	return p.runError()
+}
+
+// startSenders starts the sender pool and returns the bounded queue of
+// batches feeding it. The queue is shared by every file, so that a single
+// large file can keep all senders busy.
+func (p *Publisher) startSenders(ctx context.Context) chan *batchJob {
+	batchCh := make(chan *batchJob, p.config.Concurrency)
+	for i := 0; i < p.config.Concurrency; i++ {
+		p.senderWg.Add(1)
+		go p.sender(ctx, batchCh)
+	}
+	return batchCh
+}
+
+// runError returns the error that ended the run, if any
+func (p *Publisher) runError() error {
+	// A fatal error explains every other failure, so report it first
+	if p.fatalErr != nil {
+		return p.fatalErr
+	}
 
 	// Check if there were any errors
 	select {
@@ -158,11 +279,16 @@ func (p *Publisher) PublishDirectory(directory string) error {
 	}
 }
 
-// worker processes files from the file channel
-func (p *Publisher) worker(filesCh <-chan string) {
+// worker reads files from the file channel and queues their batches for the senders
+func (p *Publisher) worker(ctx context.Context, filesCh <-chan string, batchCh chan<- *batchJob) {
 	defer p.wg.Done()
 
 	for file := range filesCh {
+		// Stop picking up new files once the run is cancelled
+		if ctx.Err() != nil {
+			return
+		}
+
 		// Extract sourcetype from filename (remove path and extension)
 		base := filepath.Base(file)
 		sourcetype := strings.TrimSuffix(base, filepath.Ext(base))
@@ -173,9 +299,31 @@ func (p *Publisher) worker(filesCh <-chan string) {
 
 		// Update progress
 		p.progress.SetStatus(fmt.Sprintf("Processing %s", base))
+		p.progress.SetCurrentFile(base)
+
+		// Process the file, recording its outcome
+		state := &fileState{path: file, result: FileResult{File: file, SourceType: sourcetype}}
+		start := time.Now()
+		skipped, err := p.resumeFile(state)
+		if !skipped && err == nil {
+			err = p.processFile(ctx, state, batchCh)
+		}
+		result := state.finish(err, time.Since(start))
+		p.addResult(result)
+		if result.Err == nil && !skipped && p.config.Checkpoints != nil {
+			p.config.Checkpoints.Complete(file)
+		}
+
+		// Mark file as processed
+		p.progress.FileCompleted()
+		if p.config.DryRun {
+			p.progress.SetStatus("Dry run - events not sent")
+		}
+		if p.config.Debug {
+			log.Printf("DEBUG: Completed processing file %s with %d events", file, result.TotalEvents)
+		}
 
-		// Process the file
-		if err := p.processFile(file, sourcetype); err != nil {
+		if err = result.Err; err != nil {
 			if p.config.Debug {
 				log.Printf("DEBUG: Error processing file %s: %v", file, err)
 			}
@@ -188,8 +336,132 @@ func (p *Publisher) worker(filesCh <-chan string) {
 	}
 }
 
-// processFile reads a CSV file and publishes events to Splunk HEC
-func (p *Publisher) processFile(filePath, sourcetype string) error {
+// resumeFile looks up where the file's checkpoint says to continue from. It
+// reports whether the file was already completely published.
+func (p *Publisher) resumeFile(state *fileState) (bool, error) {
+	if p.config.Checkpoints == nil {
+		return false, nil
+	}
+
+	checkpoint, err := p.config.Checkpoints.Start(state.path)
+	if err != nil {
+		return false, err
+	}
+	if checkpoint.Status == CheckpointComplete {
+		if p.config.Debug {
+			log.Printf("DEBUG: Skipping %s, already published", state.path)
+		}
+		state.result.Skipped = true
+		return true, nil
+	}
+	state.result.ResumedAt = checkpoint.Rows
+	return false, nil
+}
+
+// batchJob is a batch of events from one file, queued for the sender pool
+type batchJob struct {
+	file       *fileState
+	events     []hec.EncodedEvent
+	lines      []int         // CSV line number of each event
+	lastRow    int           // Data row of the last event in the file
+	start, end int           // Position of the batch among the file's events
+	done       chan struct{} // Closed once sent, when preserving order
+}
+
+// batchAck is a sent batch waiting for the batches before it to be sent too
+type batchAck struct {
+	end, row, line int
+}
+
+// fileState tracks a file while its batches are in flight
+type fileState struct {
+	path     string
+	inFlight sync.WaitGroup
+	mu       sync.Mutex
+	result   FileResult
+	err      error
+	started  time.Time
+
+	// Batches can finish out of order. acked is the position up to which
+	// every batch has been sent, and pending holds those sent beyond it.
+	acked   int
+	pending map[int]batchAck
+}
+
+// fail records the first error from any of the file's batches
+func (f *fileState) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// failed reports whether any batch of the file has failed
+func (f *fileState) failed() bool {
+	return f.failure() != nil
+}
+
+// failure returns the first error from the file's batches, if any
+func (f *fileState) failure() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// addCounts adds to the file's published and failed event counts
+func (f *fileState) addCounts(published, failed int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.result.PublishedEvents += published
+	f.result.FailedEvents += failed
+}
+
+// acknowledge records a sent batch. If it extends the run of sent batches
+// from the start of the file, it returns the data row and line that run now
+// reaches.
+func (f *fileState) acknowledge(job *batchJob) (row, line int, advanced bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pending == nil {
+		f.pending = make(map[int]batchAck)
+	}
+	f.pending[job.start] = batchAck{end: job.end, row: job.lastRow, line: job.lines[len(job.lines)-1]}
+
+	for {
+		ack, ok := f.pending[f.acked]
+		if !ok {
+			return row, line, advanced
+		}
+		delete(f.pending, f.acked)
+		f.acked = ack.end
+		row, line, advanced = ack.row, ack.line, true
+	}
+}
+
+// finish waits for the file's batches and returns its result, preferring a
+// send error over a read error since it happened first
+func (f *fileState) finish(readErr error, elapsed time.Duration) FileResult {
+	f.inFlight.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.result.Duration = elapsed
+	f.result.Err = readErr
+	if f.err != nil {
+		f.result.Err = f.err
+	}
+	return f.result
+}
+
+// processFile streams a CSV file and queues its events in batches as they
+// are read. It returns once every batch has been queued; the caller waits
+// for them to be sent.
+func (p *Publisher) processFile(ctx context.Context, state *fileState, batchCh chan<- *batchJob) error {
+	filePath := state.path
+	sourcetype := state.result.SourceType
+
 	// Open the file
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -201,141 +473,415 @@ func (p *Publisher) processFile(filePath, sourcetype string) error {
 		log.Printf("DEBUG: Opened file %s for processing", filePath)
 	}
 
-	// Update the transformer config to use this sourcetype
-	tConfig := p.config.Transformer.Config()
-	tConfig.SourceType = sourcetype
+	// Use a transformer of this file's own, so concurrent files never share settings
+	transformer := p.config.Transformer.WithSourceType(sourcetype)
 
 	if p.config.Debug {
 		log.Printf("DEBUG: Using sourcetype: %s for events from %s", sourcetype, filePath)
 	}
 
-	// Transform the CSV to events
-	events, err := p.config.Transformer.TransformCSV(file)
+	// Stream the CSV as events
+	stream, err := transformer.NewEventStream(bufio.NewReader(file))
 	if err != nil {
 		return fmt.Errorf("error transforming CSV: %w", err)
 	}
 
+	// Continue from the checkpoint of the run being resumed
+	if rows := state.result.ResumedAt; rows > 0 {
+		if p.config.Debug {
+			log.Printf("DEBUG: Resuming %s after %d rows", filePath, rows)
+		}
+		if err := stream.SkipRows(ctx, rows); err != nil {
+			return fmt.Errorf("error resuming from checkpoint: %w", err)
+		}
+	}
+
+	// Read one batch at a time so memory stays bounded by the queue size.
+	// An event that would take a batch over its size limit starts the next one.
+	// Each event is encoded once here, and its bytes size the batch.
+	var carried *hec.EncodedEvent
+	var carriedLine, carriedRow int
+	for !state.failed() {
+		batch := make([]hec.EncodedEvent, 0, p.config.BatchSize)
+		lines := make([]int, 0, p.config.BatchSize)
+		batchBytes := p.config.HECClient.PayloadOverhead()
+		var lastRow int
+		if carried != nil {
+			batch = append(batch, *carried)
+			lines = append(lines, carriedLine)
+			lastRow = carriedRow
+			batchBytes += len(carried.Payload)
+			carried = nil
+		}
+		var readErr error
+		for len(batch) < p.config.BatchSize {
+			event, err := stream.Next(ctx)
+			if err != nil {
+				readErr = err
+				break
+			}
+			encoded, err := p.config.HECClient.Encode(event)
+			if err != nil {
+				readErr = fmt.Errorf("line %d: %w", stream.Line(), err)
+				break
+			}
+			size := len(encoded.Payload)
+			if len(batch) > 0 && batchBytes+size > p.config.BatchBytes {
+				carried, carriedLine, carriedRow = &encoded, stream.Line(), stream.Row()
+				break
+			}
+			batch = append(batch, encoded)
+			lines = append(lines, stream.Line())
+			lastRow = stream.Row()
+			batchBytes += size
+		}
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("error transforming CSV: %w", readErr)
+		}
+
+		if len(batch) > 0 {
+			job := &batchJob{
+				file:    state,
+				events:  batch,
+				lines:   lines,
+				lastRow: lastRow,
+				start:   state.result.TotalEvents,
+				end:     state.result.TotalEvents + len(batch),
+			}
+			state.result.TotalEvents = job.end
+			if p.config.PreserveOrder {
+				job.done = make(chan struct{})
+			}
+
+			// Update progress
+			p.progress.AddEvents(len(batch), 0, 0)
+
+			state.inFlight.Add(1)
+			select {
+			case batchCh <- job:
+			case <-ctx.Done():
+				state.inFlight.Done()
+				p.skipBatch(job, p.stopCause(ctx))
+				return ctx.Err()
+			}
+
+			// Wait for the batch to be sent before reading the next one
+			if job.done != nil {
+				<-job.done
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
 	if p.config.Debug {
-		log.Printf("DEBUG: Transformed %d events from %s", len(events), filePath)
-		if len(events) > 0 {
-			// Log a sample event for debugging
-			eventBytes, _ := json.Marshal(events[0])
-			log.Printf("DEBUG: Sample event: %s", string(eventBytes))
+		log.Printf("DEBUG: Finished reading file %s with %d events", filePath, state.result.TotalEvents)
+	}
+
+	return nil
+}
+
+// sender sends queued batches until the queue is closed
+func (p *Publisher) sender(ctx context.Context, batchCh <-chan *batchJob) {
+	defer p.senderWg.Done()
+
+	for job := range batchCh {
+		// Skip the remaining batches of a file that has already failed,
+		// and anything still queued once the run is cancelled
+		if err := ctx.Err(); err != nil {
+			job.file.fail(err)
+			p.skipBatch(job, p.stopCause(ctx))
+		} else if err := job.file.failure(); err != nil {
+			p.skipBatch(job, err)
+		} else if err := p.sendBatch(ctx, job); err != nil {
+			job.file.fail(err)
+		} else if p.config.Checkpoints != nil {
+			if row, line, ok := job.file.acknowledge(job); ok {
+				p.config.Checkpoints.Acknowledge(job.file.path, row, line)
+			}
+		}
+		job.file.inFlight.Done()
+		if job.done != nil {
+			close(job.done)
 		}
 	}
+}
 
-	// Update progress
-	p.progress.UpdateProgress(
-		p.progress.ProcessedFiles,
-		p.progress.TotalEvents+len(events),
-		p.progress.PublishedEvents,
-		p.progress.FailedEvents,
-		filepath.Base(filePath),
-	)
+// skipBatch accounts for a batch that won't be sent because of err. Its
+// events count as failed and are dead-lettered so they can be replayed.
+func (p *Publisher) skipBatch(job *batchJob, err error) {
+	p.deadLetter(job.file.path, "", job.lines, job.events, err)
+	job.file.addCounts(0, len(job.events))
+	p.progress.AddEvents(0, 0, len(job.events))
+}
+
+// stopCause returns why the run's context was cancelled: the fatal error
+// that aborted it, or the interrupt from the caller
+func (p *Publisher) stopCause(ctx context.Context) error {
+	p.fatalMu.Lock()
+	defer p.fatalMu.Unlock()
+	if p.fatalErr != nil {
+		return p.fatalErr
+	}
+	return ctx.Err()
+}
+
+// sendBatch sends a queued batch of events, retrying on failure
+func (p *Publisher) sendBatch(ctx context.Context, job *batchJob) error {
+	filePath, batch, start, end := job.file.path, job.events, job.start, job.end
+
+	if p.config.Debug && start == 0 {
+		// Log a sample event for debugging
+		log.Printf("DEBUG: Sample event: %s", strings.TrimSuffix(string(batch[0].Payload), ","))
+	}
 
 	// If dry run, don't actually send events
 	if p.config.DryRun {
 		if p.config.Debug {
-			log.Printf("DEBUG: Dry run - not sending %d events from %s", len(events), filePath)
-		}
-		p.progress.UpdateProgress(
-			p.progress.ProcessedFiles+1,
-			p.progress.TotalEvents,
-			p.progress.PublishedEvents+len(events),
-			p.progress.FailedEvents,
-			"",
-		)
-		p.progress.SetStatus("Dry run - events not sent")
+			log.Printf("DEBUG: Dry run - not sending batch %d-%d from %s", start, end, filePath)
+		}
+		job.file.addCounts(len(batch), 0)
+		p.progress.AddEvents(0, len(batch), 0)
 		return nil
 	}
 
-	// Send events in batches
-	for i := 0; i < len(events); i += p.config.BatchSize {
-		end := i + p.config.BatchSize
-		if end > len(events) {
-			end = len(events)
+	if p.config.Debug {
+		log.Printf("DEBUG: Sending batch %d-%d from %s", start, end, filePath)
+	}
+
+	// Try to send the batch with retries, setting aside any events HEC rejects
+	label := fmt.Sprintf("batch %d-%d from %s", start, end, filePath)
+	published, rejected, sendErr := p.sendIsolating(ctx, job.file, batch, job.lines, label)
+	if sendErr != nil {
+		job.file.addCounts(published, len(batch)-published)
+		p.progress.AddEvents(0, published, len(batch)-published)
+		return sendErr
+	}
+
+	// Update progress
+	job.file.addCounts(published, rejected)
+	p.progress.AddEvents(0, published, rejected)
+
+	// Send progress update if channel is available
+	if p.config.ProgressCh != nil {
+		select {
+		case p.config.ProgressCh <- p.progress:
+		default:
+			// Channel full or nil, continue
 		}
+	}
+
+	return nil
+}
 
-		batch := events[i:end]
+// sendIsolating sends events and, if HEC rejects their data, isolates the
+// offending events so the rest still get through. It uses the invalid event
+// number from HEC's response when there is one, and bisects the events when
+// there isn't. It returns the number of events published and rejected; an
+// error means the remaining events could not be sent at all, and they have
+// been written to the dead-letter file.
+func (p *Publisher) sendIsolating(ctx context.Context, file *fileState, events []hec.EncodedEvent, lines []int,
+	label string,
+) (published, rejected int, err error) {
+	for len(events) > 0 {
+		err := p.sendWithRetry(ctx, events, label)
+		if err == nil {
+			return published + len(events), rejected, nil
+		}
+		if hec.Classify(err) != hec.ClassRejected {
+			p.deadLetter(file.path, hec.FailedURL(err), lines, events, err)
+			return published, rejected, err
+		}
+
+		// HEC named the bad event: everything before it was indexed
+		if idx, ok := hec.InvalidEventIndex(err); ok && idx < len(events) {
+			published += idx
+			rejected++
+			p.reject(file.path, lines[idx], events[idx].Event, err)
+			events, lines = events[idx+1:], lines[idx+1:]
+			continue
+		}
+
+		// A single event that can't be sent is the culprit
+		if len(events) == 1 {
+			rejected++
+			p.reject(file.path, lines[0], events[0].Event, err)
+			return published, rejected, nil
+		}
 
+		// Otherwise send each half separately to narrow down the bad events
+		mid := len(events) / 2
 		if p.config.Debug {
-			log.Printf("DEBUG: Sending batch %d-%d of %d events from %s", i, end, len(events), filePath)
-		}
-
-		// Try to send the batch with retries
-		var sendErr error
-		for retry := 0; retry < p.config.RetryCount; retry++ {
-			if retry > 0 {
-				if p.config.Debug {
-					log.Printf("DEBUG: Retry %d/%d for batch %d-%d from %s",
-						retry+1, p.config.RetryCount, i, end, filePath)
-				}
-				p.progress.SetStatus(fmt.Sprintf("Retrying batch (%d/%d)", retry+1, p.config.RetryCount))
-				time.Sleep(p.config.RetryWait)
-			}
+			log.Printf("DEBUG: Bisecting rejected %s into %d and %d events", label, mid, len(events)-mid)
+		}
+		n, r, err := p.sendIsolating(ctx, file, events[:mid], lines[:mid], label)
+		published += n
+		rejected += r
+		if err != nil {
+			// The second half was never tried
+			p.deadLetter(file.path, "", lines[mid:], events[mid:], err)
+			return published, rejected, err
+		}
+		n, r, err = p.sendIsolating(ctx, file, events[mid:], lines[mid:], label)
+		return published + n, rejected + r, err
+	}
+	return published, rejected, nil
+}
+
+// reject sets aside an event that HEC would not accept
+func (p *Publisher) reject(file string, line int, event hec.Event, err error) {
+	if p.config.Debug {
+		log.Printf("DEBUG: Rejected event at %s:%d: %v", file, line, err)
+	}
+
+	// Report HEC's own reason rather than the retry context around it
+	url := hec.FailedURL(err)
+	var hecErr *hec.Error
+	var tooLargeErr *hec.EventTooLargeError
+	if errors.As(err, &hecErr) {
+		err = hecErr
+	} else if errors.As(err, &tooLargeErr) {
+		err = tooLargeErr
+	}
+
+	p.deadLetter(file, url, []int{line}, []hec.EncodedEvent{{Event: event}}, err)
+
+	p.rejectedMu.Lock()
+	defer p.rejectedMu.Unlock()
+	p.rejected = append(p.rejected, RejectedEvent{File: file, Line: line, Event: event, Err: err})
+}
+
+// deadLetter writes events that failed to publish to the dead-letter file, if
+// there is one. url is the HEC URL they failed against, or "" if they were
+// never sent.
+func (p *Publisher) deadLetter(file, url string, lines []int, events []hec.EncodedEvent, err error) {
+	if p.config.DeadLetter == nil {
+		return
+	}
+
+	class := failureClass(err)
+	failedAt := time.Now().UTC()
+	for i, event := range events {
+		p.config.DeadLetter.Write(DeadLetter{
+			FailedAt: failedAt,
+			URL:      url,
+			Endpoint: p.config.HECClient.Endpoint,
+			File:     file,
+			Line:     lines[i],
+			Class:    class,
+			Reason:   err.Error(),
+			Event:    event.Event,
+		})
+	}
+}
 
-			if err := p.config.HECClient.SendEvents(batch); err != nil {
-				sendErr = err
-				if p.config.Debug {
-					log.Printf("DEBUG: Batch %d-%d from %s failed: %v", i, end, filePath, err)
-				}
-				continue
+// GetRejectedEvents returns the events HEC rejected so far
+func (p *Publisher) GetRejectedEvents() []RejectedEvent {
+	p.rejectedMu.Lock()
+	defer p.rejectedMu.Unlock()
+
+	rejectedCopy := make([]RejectedEvent, len(p.rejected))
+	copy(rejectedCopy, p.rejected)
+
+	// Batches finish in any order; report in file order
+	sort.Slice(rejectedCopy, func(i, j int) bool {
+		if rejectedCopy[i].File != rejectedCopy[j].File {
+			return rejectedCopy[i].File < rejectedCopy[j].File
+		}
+		return rejectedCopy[i].Line < rejectedCopy[j].Line
+	})
+	return rejectedCopy
+}
+
+// sendWithRetry sends events, retrying transient failures with exponential
+// backoff and jitter. Rejected batches are not retried, and fatal errors
+// abort the whole run.
+func (p *Publisher) sendWithRetry(ctx context.Context, events []hec.EncodedEvent, label string) error {
+	b := newBackoff(p.config.RetryWait, p.config.RetryMaxWait, p.config.RetryMaxElapsed)
+
+	for attempt := 1; ; attempt++ {
+		// Every attempt counts towards the rate limits, since HEC may have ingested a failed one
+		if p.config.RateLimiter != nil {
+			if err := p.config.RateLimiter.Wait(ctx, events); err != nil {
+				return err
 			}
+		}
 
+		err := p.config.HECClient.SendEncoded(ctx, events)
+		if err == nil {
 			if p.config.Debug {
-				log.Printf("DEBUG: Successfully sent batch %d-%d from %s", i, end, filePath)
+				log.Printf("DEBUG: Successfully sent %s", label)
 			}
-			sendErr = nil
-			break
+			return nil
 		}
 
-		if sendErr != nil {
-			p.progress.UpdateProgress(
-				p.progress.ProcessedFiles,
-				p.progress.TotalEvents,
-				p.progress.PublishedEvents,
-				p.progress.FailedEvents+len(batch),
-				filepath.Base(filePath),
-			)
-			return fmt.Errorf("failed to send events after %d retries: %w", p.config.RetryCount, sendErr)
+		if p.config.Debug {
+			log.Printf("DEBUG: Attempt %d for %s failed (%s): %v", attempt, label, hec.Classify(err), err)
 		}
 
-		// Update progress
-		p.progress.UpdateProgress(
-			p.progress.ProcessedFiles,
-			p.progress.TotalEvents,
-			p.progress.PublishedEvents+len(batch),
-			p.progress.FailedEvents,
-			filepath.Base(filePath),
-		)
-
-		// Send progress update if channel is available
-		if p.config.ProgressCh != nil {
-			select {
-			case p.config.ProgressCh <- p.progress:
-			default:
-				// Channel full or nil, continue
-			}
+		switch hec.Classify(err) {
+		case hec.ClassFatal:
+			err = fmt.Errorf("HEC rejected the request, stopping: %w", err)
+			p.abort(err)
+			return err
+		case hec.ClassRejected:
+			return fmt.Errorf("HEC rejected %s: %w", label, err)
 		}
-	}
 
-	// Mark file as processed
-	p.progress.UpdateProgress(
-		p.progress.ProcessedFiles+1,
-		p.progress.TotalEvents,
-		p.progress.PublishedEvents,
-		p.progress.FailedEvents,
-		"",
-	)
+		if attempt >= p.config.RetryCount {
+			return fmt.Errorf("failed to send %s after %d attempts: %w", label, attempt, err)
+		}
 
-	if p.config.Debug {
-		log.Printf("DEBUG: Completed processing file %s with %d events", filePath, len(events))
+		delay, ok := b.next(hec.RetryAfter(err))
+		if !ok {
+			return fmt.Errorf("failed to send %s within %s: %w", label, p.config.RetryMaxElapsed, err)
+		}
+
+		if p.config.Debug {
+			log.Printf("DEBUG: Retrying %s in %s (%d/%d)", label, delay.Round(time.Millisecond), attempt+1, p.config.RetryCount)
+		}
+		p.progress.SetStatus(fmt.Sprintf("Retrying batch in %s (%d/%d)", delay.Round(time.Millisecond), attempt+1, p.config.RetryCount))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+}
 
-	return nil
+// abort stops the run after a fatal error, keeping the first one for PublishDirectory to report
+func (p *Publisher) abort(err error) {
+	p.fatalOnce.Do(func() {
+		p.fatalMu.Lock()
+		p.fatalErr = err
+		p.fatalMu.Unlock()
+		if p.cancel != nil {
+			p.cancel()
+		}
+	})
 }
 
 // GetProgress returns the current progress
 func (p *Publisher) GetProgress() *Progress {
 	return p.progress
 }
+
+// addResult records the outcome of a file
+func (p *Publisher) addResult(result FileResult) {
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+	p.results = append(p.results, result)
+}
+
+// GetFileResults returns the outcome of each file processed so far
+func (p *Publisher) GetFileResults() []FileResult {
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+
+	resultsCopy := make([]FileResult, len(p.results))
+	copy(resultsCopy, p.results)
+	return resultsCopy
+}
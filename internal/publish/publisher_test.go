@@ -0,0 +1,404 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thezmc/spexma/internal/publish/hec"
+)
+
+// received is an event as the fake HEC got it
+type received struct {
+	SourceType string         `json:"sourcetype"`
+	Index      string         `json:"index"`
+	Event      map[string]any `json:"event"`
+}
+
+// file and row return the source file and data row the test CSVs put in each event
+func (r received) file() string { return fmt.Sprint(r.Event["file"]) }
+func (r received) row() string  { return fmt.Sprint(r.Event["row"]) }
+
+// fakeHEC is an event endpoint that records the events it accepts. respond,
+// if set, decides each request's outcome; returning a zero status accepts it.
+// Like HEC, a response naming an invalid event still accepts those before it.
+type fakeHEC struct {
+	mu          sync.Mutex
+	delay       time.Duration
+	header      http.Header // Added to responses that aren't accepted
+	respond     func(request int, events []received) (status int, body string)
+	requests    int
+	events      []received
+	inFlight    map[string]int // Requests being handled, by source file
+	maxInFlight int
+	maxPerFile  int
+}
+
+func (f *fakeHEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var events []received
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &events); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"text":"Invalid data format","code":6}`)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests++
+	request := f.requests
+	total := 0
+	file := events[0].file()
+	f.inFlight[file]++
+	for _, n := range f.inFlight {
+		total += n
+	}
+	f.maxInFlight = max(f.maxInFlight, total)
+	f.maxPerFile = max(f.maxPerFile, f.inFlight[file])
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight[file]--
+		f.mu.Unlock()
+	}()
+
+	time.Sleep(f.delay)
+	if f.respond != nil {
+		if status, body := f.respond(request, events); status != 0 {
+			var response struct {
+				InvalidEvent *int `json:"invalid-event-number"`
+			}
+			if json.Unmarshal([]byte(body), &response) == nil && response.InvalidEvent != nil {
+				f.mu.Lock()
+				f.events = append(f.events, events[:*response.InvalidEvent]...)
+				f.mu.Unlock()
+			}
+			for key, values := range f.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+			return
+		}
+	}
+
+	f.mu.Lock()
+	f.events = append(f.events, events...)
+	f.mu.Unlock()
+	fmt.Fprint(w, `{"text":"Success","code":0}`)
+}
+
+// byFile returns the rows of the accepted events of each file, in the order they arrived
+func (f *fakeHEC) byFile() map[string][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rows := make(map[string][]string)
+	for _, event := range f.events {
+		rows[event.file()] = append(rows[event.file()], event.row())
+	}
+	return rows
+}
+
+// newTestPublisher starts a fake HEC and returns a publisher sending to it.
+// Retries wait a millisecond, and config's other zero values take the
+// publisher's defaults.
+func newTestPublisher(t *testing.T, config PublisherConfig) (*Publisher, *fakeHEC) {
+	t.Helper()
+	fake := &fakeHEC{inFlight: make(map[string]int)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := hec.NewClient(server.URL+"/services/collector/event", "secret", &hec.Options{
+		Timeout:     5 * time.Second,
+		Concurrency: config.Concurrency,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.HECClient = client
+	if config.Transformer == nil {
+		config.Transformer = NewTransformer(&TransformerConfig{TimeField: "_time", TimeFormat: "epoch"})
+	}
+	if config.RetryWait == 0 {
+		config.RetryWait = time.Millisecond
+		config.RetryMaxWait = 5 * time.Millisecond
+	}
+	return NewPublisher(&config), fake
+}
+
+// writeTestCSVs writes a CSV for each sourcetype in files, with the given
+// number of rows. Each row records its file and 1-based row number.
+func writeTestCSVs(t *testing.T, files map[string]int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, rows := range files {
+		var b strings.Builder
+		b.WriteString("_time,file,row\n")
+		for row := 1; row <= rows; row++ {
+			fmt.Fprintf(&b, "%d,%s,%d\n", 1700000000+row, name, row)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".csv"), []byte(b.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// rowRange returns the row numbers from first to last as strings
+func rowRange(first, last int) []string {
+	var rows []string
+	for row := first; row <= last; row++ {
+		rows = append(rows, fmt.Sprint(row))
+	}
+	return rows
+}
+
+func TestPublishSenderPool(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         map[string]int
+		preserveOrder bool
+		wantInFlight  int // Requests in flight at once, overall
+		wantPerFile   int // Requests in flight at once for a single file
+	}{
+		{
+			name:         "one large file uses every sender",
+			files:        map[string]int{"sysmon": 400},
+			wantInFlight: 4,
+			wantPerFile:  4,
+		},
+		{
+			name:          "preserving order sends one batch per file at a time",
+			files:         map[string]int{"sysmon": 200, "wineventlog": 200, "syslog": 200},
+			preserveOrder: true,
+			wantInFlight:  3,
+			wantPerFile:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestCSVs(t, tt.files)
+			p, fake := newTestPublisher(t, PublisherConfig{
+				Concurrency:   4,
+				BatchSize:     10,
+				PreserveOrder: tt.preserveOrder,
+			})
+			fake.delay = 20 * time.Millisecond
+
+			if err := p.PublishDirectory(context.Background(), dir); err != nil {
+				t.Fatal(err)
+			}
+
+			if fake.maxInFlight != tt.wantInFlight {
+				t.Errorf("got %d requests in flight at once, want %d", fake.maxInFlight, tt.wantInFlight)
+			}
+			if fake.maxPerFile != tt.wantPerFile {
+				t.Errorf("got %d requests in flight at once for a file, want %d", fake.maxPerFile, tt.wantPerFile)
+			}
+
+			// Every event arrives once; with preserved order, in file order
+			for name, rows := range tt.files {
+				got := fake.byFile()[name]
+				if len(got) != rows {
+					t.Errorf("%s: got %d events, want %d", name, len(got), rows)
+				}
+				if tt.preserveOrder && strings.Join(got, ",") != strings.Join(rowRange(1, rows), ",") {
+					t.Errorf("%s: events arrived out of order: %v", name, got)
+				}
+			}
+		})
+	}
+}
+
+// rejectRows is a fakeHEC response that rejects requests holding an event
+// whose row is bad. named has it give the first bad event's number, as HEC
+// does for events it can't parse.
+func rejectRows(named bool, bad func(row int) bool) func(int, []received) (int, string) {
+	return func(_ int, events []received) (int, string) {
+		for i, event := range events {
+			var row int
+			fmt.Sscan(event.row(), &row)
+			if !bad(row) {
+				continue
+			}
+			if named {
+				return http.StatusBadRequest, fmt.Sprintf(`{"text":"Invalid data format","code":6,"invalid-event-number":%d}`, i)
+			}
+			return http.StatusBadRequest, `{"text":"Invalid data format","code":6}`
+		}
+		return 0, ""
+	}
+}
+
+func TestPublishCounts(t *testing.T) {
+	files := map[string]int{}
+	total := 0
+	for i := 1; i <= 8; i++ {
+		files[fmt.Sprintf("sourcetype_%d", i)] = i * 137
+		total += i * 137
+	}
+
+	tests := []struct {
+		name       string
+		rejectEach int // Reject rows that are a multiple of this, if set
+	}{
+		{name: "all published"},
+		{name: "some rejected", rejectEach: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestCSVs(t, files)
+			p, fake := newTestPublisher(t, PublisherConfig{Concurrency: 8, BatchSize: 25})
+			wantFailed := func(rows int) int { return 0 }
+			if tt.rejectEach > 0 {
+				fake.respond = rejectRows(true, func(row int) bool { return row%tt.rejectEach == 0 })
+				wantFailed = func(rows int) int { return rows / tt.rejectEach }
+			}
+
+			if err := p.PublishDirectory(context.Background(), dir); err != nil {
+				t.Fatal(err)
+			}
+
+			// Each file's events carry its own sourcetype
+			for _, event := range fake.events {
+				if event.SourceType != event.file() {
+					t.Fatalf("event from %s has sourcetype %s", event.file(), event.SourceType)
+				}
+			}
+
+			failed := 0
+			for _, result := range p.GetFileResults() {
+				rows := files[result.SourceType]
+				if result.TotalEvents != rows || result.FailedEvents != wantFailed(rows) ||
+					result.PublishedEvents != rows-wantFailed(rows) {
+					t.Errorf("%s: got %d total, %d published and %d failed events, want %d, %d and %d", result.SourceType,
+						result.TotalEvents, result.PublishedEvents, result.FailedEvents, rows, rows-wantFailed(rows), wantFailed(rows))
+				}
+				failed += wantFailed(rows)
+			}
+
+			processed, totalFiles, published, totalEvents, _, _, _ := p.GetProgress().GetStats()
+			if processed != len(files) || totalFiles != len(files) {
+				t.Errorf("got %d of %d files processed, want %d", processed, totalFiles, len(files))
+			}
+			if totalEvents != total || published != total-failed || p.GetProgress().GetFailedEvents() != failed {
+				t.Errorf("got progress of %d total, %d published and %d failed events, want %d, %d and %d",
+					totalEvents, published, p.GetProgress().GetFailedEvents(), total, total-failed, failed)
+			}
+			if len(fake.events) != total-failed {
+				t.Errorf("fake HEC got %d events, want %d", len(fake.events), total-failed)
+			}
+		})
+	}
+}
+
+func TestWithSourceType(t *testing.T) {
+	transformer := NewTransformer(&TransformerConfig{SourceType: "original"})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sourcetype := fmt.Sprintf("sourcetype_%d", i)
+			if got := transformer.WithSourceType(sourcetype).Config().SourceType; got != sourcetype {
+				t.Errorf("got sourcetype %s, want %s", got, sourcetype)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := transformer.Config().SourceType; got != "original" {
+		t.Errorf("shared config's sourcetype changed to %s", got)
+	}
+}
+
+func TestPublishIsolatesRejectedEvents(t *testing.T) {
+	tests := []struct {
+		name         string
+		named        bool // HEC names the invalid event
+		bad          []int
+		wantRequests int // If set
+	}{
+		{
+			name:         "named event",
+			named:        true,
+			bad:          []int{4},
+			wantRequests: 2,
+		},
+		{
+			name:         "several named events",
+			named:        true,
+			bad:          []int{1, 4, 10},
+			wantRequests: 3,
+		},
+		{
+			name: "unnamed events are found by bisecting",
+			bad:  []int{4, 7},
+		},
+		{
+			name: "every event unnamed",
+			bad:  []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad := make(map[int]bool)
+			for _, row := range tt.bad {
+				bad[row] = true
+			}
+			dir := writeTestCSVs(t, map[string]int{"sysmon": 10})
+			p, fake := newTestPublisher(t, PublisherConfig{Concurrency: 1, BatchSize: 10})
+			fake.respond = rejectRows(tt.named, func(row int) bool { return bad[row] })
+
+			if err := p.PublishDirectory(context.Background(), dir); err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the bad rows are missing, and the rest arrive once, in order
+			var want []string
+			for row := 1; row <= 10; row++ {
+				if !bad[row] {
+					want = append(want, fmt.Sprint(row))
+				}
+			}
+			got := fake.byFile()["sysmon"]
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("got rows %v, want %v", got, want)
+			}
+			if tt.wantRequests > 0 && fake.requests != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", fake.requests, tt.wantRequests)
+			}
+
+			// Each bad row is reported once, at its line: the header is line 1
+			rejected := p.GetRejectedEvents()
+			if len(rejected) != len(tt.bad) {
+				t.Fatalf("got %d rejected events, want %d", len(rejected), len(tt.bad))
+			}
+			for i, event := range rejected {
+				row := tt.bad[i]
+				if event.Line != row+1 || event.Event.Event["row"] != fmt.Sprint(row) || !strings.HasSuffix(event.File, "sysmon.csv") {
+					t.Errorf("got rejected event %s:%d %v, want row %d at line %d", event.File, event.Line, event.Event.Event, row, row+1)
+				}
+				if event.Err == nil || !strings.Contains(event.Err.Error(), "Invalid data format") {
+					t.Errorf("got rejected event error %v, want HEC's reason", event.Err)
+				}
+			}
+
+			result := p.GetFileResults()[0]
+			if result.PublishedEvents != len(want) || result.FailedEvents != len(tt.bad) {
+				t.Errorf("got %d published and %d failed events, want %d and %d",
+					result.PublishedEvents, result.FailedEvents, len(want), len(tt.bad))
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thezmc/spexma/internal/publish/hec"
+)
+
+// Event metadata rate limits can be applied per value of
+const (
+	LimitBySourceType = "sourcetype"
+	LimitByIndex      = "index"
+)
+
+// rateWindow is how many whole seconds the measured rate is averaged over
+const rateWindow = 5
+
+// RateLimits are the send rates a run must stay under. Zero means no limit.
+type RateLimits struct {
+	EventsPerSecond float64 // Across all workers
+	BytesPerSecond  float64 // Payload bytes, before compression, across all workers
+
+	// With By set to LimitBySourceType or LimitByIndex, each distinct
+	// sourcetype or index also gets these limits of its own
+	By                  string
+	EventsPerSecondEach float64
+	BytesPerSecondEach  float64
+}
+
+// Enabled reports whether any limit is set
+func (l RateLimits) Enabled() bool {
+	return l.EventsPerSecond > 0 || l.BytesPerSecond > 0 ||
+		(l.By != "" && (l.EventsPerSecondEach > 0 || l.BytesPerSecondEach > 0))
+}
+
+// RateStat is the measured rate of one limit, for display
+type RateStat struct {
+	Name  string  // "all", or the sourcetype or index the limit applies to
+	Unit  string  // "events" or "bytes"
+	Rate  float64 // Per second, averaged over the last few seconds
+	Limit float64 // Per second
+}
+
+// RateLimiter holds sends back to stay under RateLimits. It is shared by
+// every sender of a run and is safe for concurrent use.
+type RateLimiter struct {
+	limits       RateLimits
+	events       *tokenBucket
+	bytes        *tokenBucket
+	mu           sync.Mutex
+	eachEvents   map[string]*tokenBucket
+	eachBytes    map[string]*tokenBucket
+	measureBytes bool
+}
+
+// NewRateLimiter creates a limiter for limits. It returns nil if limits has
+// no limits set.
+func NewRateLimiter(limits RateLimits) (*RateLimiter, error) {
+	if !limits.Enabled() {
+		return nil, nil
+	}
+	switch limits.By {
+	case "", LimitBySourceType, LimitByIndex:
+	default:
+		return nil, fmt.Errorf("invalid rate limit key %q (expected %s or %s)", limits.By, LimitBySourceType, LimitByIndex)
+	}
+
+	l := &RateLimiter{
+		limits:     limits,
+		eachEvents: make(map[string]*tokenBucket),
+		eachBytes:  make(map[string]*tokenBucket),
+	}
+	if limits.EventsPerSecond > 0 {
+		l.events = newTokenBucket(limits.EventsPerSecond)
+	}
+	if limits.BytesPerSecond > 0 {
+		l.bytes = newTokenBucket(limits.BytesPerSecond)
+	}
+	l.measureBytes = limits.BytesPerSecond > 0 || (limits.By != "" && limits.BytesPerSecondEach > 0)
+	return l, nil
+}
+
+// Wait blocks until events can be sent without exceeding any limit, or
+// until ctx is done. A batch larger than a second's allowance is let
+// through and paid for by the batches after it.
+func (l *RateLimiter) Wait(ctx context.Context, events []hec.EncodedEvent) error {
+	var bytes int
+	eachCount := make(map[string]int)
+	eachBytes := make(map[string]int)
+	for _, event := range events {
+		size := 0
+		if l.measureBytes {
+			size = len(event.Payload)
+			bytes += size
+		}
+		if l.limits.By != "" {
+			key := l.key(event.Event)
+			eachCount[key]++
+			eachBytes[key] += size
+		}
+	}
+
+	// Take from every bucket the events count against, then wait for the slowest
+	now := time.Now()
+	var delay time.Duration
+	var taken []*tokenBucket
+	var amounts []float64
+	take := func(bucket *tokenBucket, n float64) {
+		if bucket == nil {
+			return
+		}
+		delay = max(delay, bucket.take(now, n))
+		taken = append(taken, bucket)
+		amounts = append(amounts, n)
+	}
+	take(l.events, float64(len(events)))
+	take(l.bytes, float64(bytes))
+	for key, count := range eachCount {
+		eventsBucket, bytesBucket := l.eachBuckets(key)
+		take(eventsBucket, float64(count))
+		take(bytesBucket, float64(eachBytes[key]))
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	sent := time.Now()
+	for i, bucket := range taken {
+		bucket.meter.add(sent, amounts[i])
+	}
+	return nil
+}
+
+// key returns the sourcetype or index an event counts against. Encoded
+// events already carry the client's default index.
+func (l *RateLimiter) key(event hec.Event) string {
+	if l.limits.By == LimitBySourceType {
+		return event.SourceType
+	}
+	if event.Index == "" {
+		return "(default)"
+	}
+	return event.Index
+}
+
+// eachBuckets returns the buckets of a sourcetype or index, creating them on first use
+func (l *RateLimiter) eachBuckets(key string) (events, bytes *tokenBucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.eachEvents[key]; !ok {
+		l.eachEvents[key] = nil
+		l.eachBytes[key] = nil
+		if l.limits.EventsPerSecondEach > 0 {
+			l.eachEvents[key] = newTokenBucket(l.limits.EventsPerSecondEach)
+		}
+		if l.limits.BytesPerSecondEach > 0 {
+			l.eachBytes[key] = newTokenBucket(l.limits.BytesPerSecondEach)
+		}
+	}
+	return l.eachEvents[key], l.eachBytes[key]
+}
+
+// Stats returns the measured rate of every limit, the overall ones first
+func (l *RateLimiter) Stats() []RateStat {
+	now := time.Now()
+	var stats []RateStat
+	add := func(name, unit string, bucket *tokenBucket) {
+		if bucket != nil {
+			stats = append(stats, RateStat{Name: name, Unit: unit, Rate: bucket.meter.rate(now), Limit: bucket.rate})
+		}
+	}
+	add("all", "events", l.events)
+	add("all", "bytes", l.bytes)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make([]string, 0, len(l.eachEvents))
+	for key := range l.eachEvents {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(key, "events", l.eachEvents[key])
+		add(key, "bytes", l.eachBytes[key])
+	}
+	return stats
+}
+
+// tokenBucket allows rate tokens a second, with up to a second's worth saved up
+type tokenBucket struct {
+	rate   float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	meter  rateMeter
+}
+
+// newTokenBucket creates a full bucket
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// take removes n tokens, going into debt if there aren't enough, and
+// returns how long to wait until the debt is paid off
+func (b *tokenBucket) take(now time.Time, n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.last) {
+		b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateMeter measures a rate over the last rateWindow whole seconds
+type rateMeter struct {
+	mu      sync.Mutex
+	counts  [rateWindow + 1]float64
+	seconds [rateWindow + 1]int64
+	first   int64 // The second of the first count, or 0 before there is one
+}
+
+// add counts n at the given time
+func (m *rateMeter) add(now time.Time, n float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	second := now.Unix()
+	if m.first == 0 {
+		m.first = second
+	}
+	slot := second % int64(len(m.counts))
+	if m.seconds[slot] != second {
+		m.seconds[slot] = second
+		m.counts[slot] = 0
+	}
+	m.counts[slot] += n
+}
+
+// rate returns the average per second over the last rateWindow whole
+// seconds, or over the whole seconds since the first count if fewer
+func (m *rateMeter) rate(now time.Time) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	second := now.Unix()
+	elapsed := min(second-m.first, rateWindow)
+	if m.first == 0 || elapsed <= 0 {
+		return 0
+	}
+	var total float64
+	for i, s := range m.seconds {
+		if s < second && s >= second-rateWindow {
+			total += m.counts[i]
+		}
+	}
+	return total / float64(elapsed)
+}
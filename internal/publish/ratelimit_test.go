@@ -0,0 +1,133 @@
+package publish
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thezmc/spexma/internal/publish/hec"
+)
+
+func TestRateMeter(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		counts map[int]float64 // Counted in each second from start
+		at     int             // Seconds from start the rate is read at
+		want   float64
+	}{
+		{"nothing counted", nil, 3, 0},
+		{"first second still counting", map[int]float64{0: 100}, 0, 0},
+		{"one whole second", map[int]float64{0: 100}, 1, 100},
+		{"two whole seconds", map[int]float64{0: 100, 1: 300}, 2, 200},
+		{"full window", map[int]float64{0: 50, 1: 50, 2: 50, 3: 50, 4: 50, 5: 50}, 6, 50},
+		{"older seconds drop out", map[int]float64{0: 1000, 6: 50, 7: 50, 8: 50, 9: 50, 10: 50}, 11, 50},
+		{"idle seconds count", map[int]float64{0: 500}, 5, 100},
+		{"idle past the window", map[int]float64{0: 500}, 20, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var meter rateMeter
+			for second := 0; second <= tt.at; second++ {
+				if n, ok := tt.counts[second]; ok {
+					meter.add(start.Add(time.Duration(second)*time.Second+300*time.Millisecond), n)
+				}
+			}
+			if got := meter.rate(start.Add(time.Duration(tt.at)*time.Second + 500*time.Millisecond)); got != tt.want {
+				t.Errorf("got rate %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name  string
+		takes []float64       // Taken in turn at the times below
+		at    []time.Duration // After the bucket is created
+		want  []time.Duration // Wait returned by each take
+	}{
+		{
+			name:  "a second's worth at once",
+			takes: []float64{100},
+			at:    []time.Duration{0},
+			want:  []time.Duration{0},
+		},
+		{
+			name:  "debt is paid off at the rate",
+			takes: []float64{100, 50},
+			at:    []time.Duration{0, 0},
+			want:  []time.Duration{0, 500 * time.Millisecond},
+		},
+		{
+			name:  "tokens refill over time",
+			takes: []float64{100, 50},
+			at:    []time.Duration{0, 500 * time.Millisecond},
+			want:  []time.Duration{0, 0},
+		},
+		{
+			name:  "no more than a second's worth saved up",
+			takes: []float64{150},
+			at:    []time.Duration{10 * time.Second},
+			want:  []time.Duration{500 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := &tokenBucket{rate: 100, tokens: 100, last: start}
+			for i, n := range tt.takes {
+				if got := bucket.take(start.Add(tt.at[i]), n); got != tt.want[i] {
+					t.Errorf("take %d: got wait %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimits{
+		EventsPerSecond:     1000,
+		By:                  LimitBySourceType,
+		EventsPerSecondEach: 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := func(sourcetype string, n int) []hec.EncodedEvent {
+		batch := make([]hec.EncodedEvent, n)
+		for i := range batch {
+			batch[i].SourceType = sourcetype
+		}
+		return batch
+	}
+	ctx := context.Background()
+
+	// A sourcetype's allowance doesn't hold back another's
+	if err := limiter.Wait(ctx, events("a", 20)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := limiter.Wait(ctx, events("b", 20)); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > 25*time.Millisecond {
+		t.Errorf("sourcetype b waited %v behind sourcetype a", waited)
+	}
+
+	// Going over the allowance waits for it, unless cancelled
+	start = time.Now()
+	if err := limiter.Wait(ctx, events("a", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 75*time.Millisecond {
+		t.Errorf("sourcetype a waited %v over its limit, want about 100ms", waited)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limiter.Wait(cancelled, events("a", 20)); err != context.Canceled {
+		t.Errorf("got %v from a cancelled wait, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,157 @@
+package publish
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReplayFile resends the events of a dead-letter file. Events are batched
+// per CSV file they were originally read from, so file results and any new
+// dead letters still point back at the source. Events that fail again, or
+// are never sent because the run stopped, go to the configured dead-letter
+// writer, since the replayed file is their only other copy.
+func (p *Publisher) ReplayFile(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening dead-letter file: %w", err)
+	}
+	defer file.Close()
+
+	// Cancel everything in flight if a fatal error is hit
+	ctx, p.cancel = context.WithCancel(ctx)
+	defer p.cancel()
+
+	p.progress.SetStatus("Replaying")
+
+	// Start the sender pool and queue the file's events for it
+	batchCh := p.startSenders(ctx)
+	states, readErr := p.queueDeadLetters(ctx, NewDeadLetterReader(bufio.NewReader(file)), batchCh)
+	close(batchCh)
+	p.senderWg.Wait()
+
+	// Record the outcome of each source file
+	for _, state := range states {
+		result := state.finish(nil, time.Since(state.started))
+		p.addResult(result)
+		p.progress.FileCompleted()
+
+		if result.Err != nil {
+			select {
+			case p.errorChan <- fmt.Errorf("error replaying events from %s: %w", result.File, result.Err):
+			default:
+				// Channel full, continue
+			}
+		}
+	}
+
+	if readErr != nil {
+		return readErr
+	}
+	return p.runError()
+}
+
+// queueDeadLetters reads dead-letter records and queues them in batches,
+// starting a new batch whenever the source file changes. It returns the
+// state of every source file seen, in order.
+func (p *Publisher) queueDeadLetters(ctx context.Context, reader *DeadLetterReader, batchCh chan<- *batchJob) ([]*fileState, error) {
+	var states []*fileState
+	byFile := make(map[string]*fileState)
+	var job *batchJob
+	var jobBytes int
+
+	// queue hands the pending batch to the senders. Once the run has
+	// stopped, batches are skipped instead, which dead-letters them.
+	queue := func() {
+		if job == nil {
+			return
+		}
+		pending := job
+		job = nil
+
+		state := pending.file
+		state.result.TotalEvents = pending.end
+		if p.config.PreserveOrder {
+			pending.done = make(chan struct{})
+		}
+		p.progress.AddEvents(len(pending.events), 0, 0)
+
+		if ctx.Err() == nil {
+			state.inFlight.Add(1)
+			select {
+			case batchCh <- pending:
+				// Wait for the batch to be sent before queueing the next one
+				if pending.done != nil {
+					<-pending.done
+				}
+				return
+			case <-ctx.Done():
+				state.inFlight.Done()
+			}
+		}
+		state.fail(ctx.Err())
+		p.skipBatch(pending, p.stopCause(ctx))
+	}
+
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			queue()
+			return states, err
+		}
+
+		state, ok := byFile[record.File]
+		if !ok {
+			state = &fileState{
+				path:    record.File,
+				result:  FileResult{File: record.File, SourceType: record.Event.SourceType},
+				started: time.Now(),
+			}
+			byFile[record.File] = state
+			states = append(states, state)
+
+			p.progress.SetTotalFiles(len(states))
+			p.progress.SetCurrentFile(filepath.Base(record.File))
+			if p.config.Debug {
+				log.Printf("DEBUG: Replaying events from %s", record.File)
+			}
+		}
+
+		event := record.Event
+		if p.config.ReplayIndex != "" {
+			event.Index = p.config.ReplayIndex
+		}
+		encoded, err := p.config.HECClient.Encode(event)
+		if err != nil {
+			queue()
+			return states, fmt.Errorf("dead-letter event from %s line %d: %w", record.File, record.Line, err)
+		}
+		size := len(encoded.Payload)
+
+		if job != nil && (job.file != state || len(job.events) == p.config.BatchSize ||
+			jobBytes+size > p.config.BatchBytes) {
+			queue()
+		}
+
+		if job == nil {
+			start := state.result.TotalEvents
+			job = &batchJob{file: state, start: start, end: start}
+			jobBytes = p.config.HECClient.PayloadOverhead()
+		}
+		jobBytes += size
+		job.events = append(job.events, encoded)
+		job.lines = append(job.lines, record.Line)
+		job.end++
+	}
+	queue()
+
+	return states, nil
+}
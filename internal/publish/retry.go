@@ -0,0 +1,48 @@
+package publish
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff computes exponentially growing, jittered delays between retries
+// of a single batch
+type backoff struct {
+	initial    time.Duration // Delay before the first retry
+	max        time.Duration // Cap on any single delay
+	maxElapsed time.Duration // Give up once this much time has passed, if set
+	start      time.Time
+	attempt    int
+}
+
+// newBackoff starts a backoff for a new batch
+func newBackoff(initial, max, maxElapsed time.Duration) *backoff {
+	return &backoff{
+		initial:    initial,
+		max:        max,
+		maxElapsed: maxElapsed,
+		start:      time.Now(),
+	}
+}
+
+// next returns the delay before the next retry, honouring a server-requested
+// minimum. It returns false once the elapsed time budget is spent.
+func (b *backoff) next(minimum time.Duration) (time.Duration, bool) {
+	// Exponential growth, capped
+	ceiling := b.initial << b.attempt
+	if ceiling <= 0 || ceiling > b.max {
+		ceiling = b.max
+	}
+	b.attempt++
+
+	// Equal jitter: half fixed, half random, so delays stay spread out but never collapse to zero
+	delay := ceiling/2 + rand.N(ceiling/2+1)
+	if delay < minimum {
+		delay = minimum
+	}
+
+	if b.maxElapsed > 0 && time.Since(b.start)+delay > b.maxElapsed {
+		return 0, false
+	}
+	return delay, true
+}
@@ -0,0 +1,127 @@
+package publish
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	// Each delay falls between half its ceiling and the ceiling, which doubles up to the max
+	b := newBackoff(100*time.Millisecond, time.Second, 0)
+	for i, ceiling := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		ceiling *= time.Millisecond
+		delay, ok := b.next(0)
+		if !ok || delay < ceiling/2 || delay > ceiling {
+			t.Errorf("retry %d: got delay %v (%v), want %v to %v", i+1, delay, ok, ceiling/2, ceiling)
+		}
+	}
+
+	// Growth past the shift width stays at the max
+	b.attempt = 70
+	if delay, _ := b.next(0); delay < 500*time.Millisecond || delay > time.Second {
+		t.Errorf("got delay %v after many retries, want 500ms to 1s", delay)
+	}
+
+	// A server-requested minimum wins, even over the max
+	if delay, _ := b.next(3 * time.Second); delay != 3*time.Second {
+		t.Errorf("got delay %v, want the requested 3s", delay)
+	}
+}
+
+func TestBackoffMaxElapsed(t *testing.T) {
+	b := newBackoff(200*time.Millisecond, time.Second, time.Second)
+	if _, ok := b.next(0); !ok {
+		t.Error("gave up before the time budget was spent")
+	}
+
+	// Any delay would now end past the budget
+	b.start = time.Now().Add(-900 * time.Millisecond)
+	if delay, ok := b.next(0); ok {
+		t.Errorf("got delay %v, want to give up", delay)
+	}
+}
+
+func TestPublishRetries(t *testing.T) {
+	const busy = `{"text":"Server is busy","code":9}`
+	tests := []struct {
+		name         string
+		respond      func(request int, events []received) (int, string)
+		retryAfter   string
+		wantRequests int
+		wantEvents   int
+		wantErr      string
+	}{
+		{
+			name: "busy server accepts a retry",
+			respond: func(request int, _ []received) (int, string) {
+				if request < 3 {
+					return http.StatusServiceUnavailable, busy
+				}
+				return 0, ""
+			},
+			wantRequests: 3,
+			wantEvents:   5,
+		},
+		{
+			name: "retries run out",
+			respond: func(int, []received) (int, string) {
+				return http.StatusServiceUnavailable, busy
+			},
+			wantRequests: 3,
+			wantErr:      "after 3 attempts",
+		},
+		{
+			name: "fatal error stops the run without retrying",
+			respond: func(int, []received) (int, string) {
+				return http.StatusForbidden, `{"text":"Invalid token","code":4}`
+			},
+			wantRequests: 1,
+			wantErr:      "HEC rejected the request, stopping",
+		},
+		{
+			name: "Retry-After is honoured",
+			respond: func(request int, _ []received) (int, string) {
+				if request == 1 {
+					return http.StatusServiceUnavailable, busy
+				}
+				return 0, ""
+			},
+			retryAfter:   "1",
+			wantRequests: 2,
+			wantEvents:   5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestCSVs(t, map[string]int{"sysmon": 5})
+			p, fake := newTestPublisher(t, PublisherConfig{Concurrency: 1, RetryCount: 3})
+			fake.respond = tt.respond
+			if tt.retryAfter != "" {
+				fake.header = http.Header{"Retry-After": {tt.retryAfter}}
+			}
+
+			start := time.Now()
+			err := p.PublishDirectory(context.Background(), dir)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if tt.retryAfter != "" && time.Since(start) < time.Second {
+				t.Errorf("retried after %v, want the requested 1s", time.Since(start))
+			}
+
+			if fake.requests != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", fake.requests, tt.wantRequests)
+			}
+			if len(fake.events) != tt.wantEvents {
+				t.Errorf("got %d events, want %d", len(fake.events), tt.wantEvents)
+			}
+		})
+	}
+}
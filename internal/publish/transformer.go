@@ -1,8 +1,10 @@
 package publish
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
@@ -10,6 +12,7 @@ import (
 	"time"
 
 	"github.com/araddon/dateparse"
+	"github.com/thezmc/spexma/internal/common/display"
 	"github.com/thezmc/spexma/internal/publish/hec"
 )
 
@@ -29,6 +32,9 @@ type TransformerConfig struct {
 	DiscardInvalid   bool              // If true, discard records with invalid timestamps
 	DefaultTimestamp *time.Time        // Default timestamp to use if not present or invalid
 	TimeOffset       time.Duration     // Offset to apply to the timestamp
+	RawMode          bool              // If true, build events for the raw endpoint: _raw verbatim, metadata from the CSV
+	IndexedFields    []string          // CSV columns or constant fields to send as HEC indexed fields, under their mapped names
+	KeepIndexed      bool              // If true, indexed fields are also kept in the event body
 }
 
 // NewDefaultConfig creates a default transformer configuration
@@ -43,6 +49,40 @@ func NewDefaultTransformerConfig() *TransformerConfig {
 	}
 }
 
+// ValidateIndexedFields checks that HEC will accept the indexed fields under
+// the names they're sent as: a constant field's own name, or a column's
+// mapped name
+func (c *TransformerConfig) ValidateIndexedFields() error {
+	fields := make(map[string]any)
+	sources := make(map[string]string)
+	for _, field := range c.IndexedFields {
+		name, value := c.mappedName(field), any("")
+		if constant, ok := c.ConstantFields[field]; ok {
+			name, value = field, constant
+		}
+		if err := hec.ValidateFieldName(name); err != nil {
+			if name != field {
+				return fmt.Errorf("indexed field '%s' is renamed by the field mappings: %w", field, err)
+			}
+			return err
+		}
+		if other, ok := sources[name]; ok {
+			return fmt.Errorf("indexed fields '%s' and '%s' are both sent as '%s'", other, field, name)
+		}
+		sources[name] = field
+		fields[name] = value
+	}
+	return hec.ValidateFields(fields)
+}
+
+// mappedName returns the name a CSV column is sent under
+func (c *TransformerConfig) mappedName(field string) string {
+	if mappedName, ok := c.FieldMappings[field]; ok {
+		return mappedName
+	}
+	return field
+}
+
 // Transformer converts CSV records to Splunk events
 type Transformer struct {
 	config *TransformerConfig
@@ -63,8 +103,31 @@ func (t *Transformer) Config() *TransformerConfig {
 	return t.config
 }
 
-// TransformCSV reads a CSV file and returns Splunk events
-func (t *Transformer) TransformCSV(reader io.Reader) ([]hec.Event, error) {
+// WithSourceType returns a transformer that uses the given sourcetype,
+// leaving this transformer's configuration untouched. The copy shares the
+// read-only field lists and maps.
+func (t *Transformer) WithSourceType(sourcetype string) *Transformer {
+	config := *t.config
+	config.SourceType = sourcetype
+	return &Transformer{config: &config}
+}
+
+// EventStream transforms a CSV into Splunk events one record at a time, so
+// callers never need to hold a whole file's events in memory
+type EventStream struct {
+	t             *Transformer
+	csvReader     *csv.Reader
+	header        []string
+	headerIndices map[string]int
+	timeIndex     int
+	excludeFields map[string]bool
+	indexedFields map[string]bool
+	line          int
+	rows          int // Data rows read so far, including any discarded
+}
+
+// NewEventStream reads the CSV header from reader and returns a stream of its events
+func (t *Transformer) NewEventStream(reader io.Reader) (*EventStream, error) {
 	csvReader := csv.NewReader(reader)
 
 	// Read the header
@@ -87,6 +150,14 @@ func (t *Transformer) TransformCSV(reader io.Reader) ([]hec.Event, error) {
 		if !ok && !t.config.DiscardInvalid && t.config.DefaultTimestamp == nil {
 			return nil, fmt.Errorf("time field '%s' not found in CSV header", t.config.TimeField)
 		}
+		if !ok {
+			timeIndex = -1
+		}
+	}
+
+	// The raw endpoint can only resend the original event text
+	if _, ok := headerIndices[hec.RawField]; t.config.RawMode && !ok {
+		return nil, fmt.Errorf("no %s column in CSV header, which the raw endpoint needs", hec.RawField)
 	}
 
 	// Create a set of excluded fields for quick lookups
@@ -95,39 +166,137 @@ func (t *Transformer) TransformCSV(reader io.Reader) ([]hec.Event, error) {
 		excludeFields[field] = true
 	}
 
-	// Process records
-	var events []hec.Event
-	lineNum := 1 // Start at 1 because we already read the header
+	// Check the indexed fields will be accepted by HEC
+	if err := t.config.ValidateIndexedFields(); err != nil {
+		return nil, err
+	}
+	indexedFields := make(map[string]bool)
+	for _, field := range t.config.IndexedFields {
+		indexedFields[field] = true
+	}
 
+	return &EventStream{
+		t:             t,
+		csvReader:     csvReader,
+		header:        header,
+		headerIndices: headerIndices,
+		timeIndex:     timeIndex,
+		excludeFields: excludeFields,
+		indexedFields: indexedFields,
+		line:          1,
+	}, nil
+}
+
+// Next returns the next event in the CSV. It returns io.EOF once the CSV is
+// exhausted, or the context's error if it is cancelled.
+func (s *EventStream) Next(ctx context.Context) (hec.Event, error) {
 	for {
-		lineNum++
-		record, err := csvReader.Read()
+		if err := ctx.Err(); err != nil {
+			return hec.Event{}, err
+		}
+
+		record, err := s.csvReader.Read()
 		if err == io.EOF {
-			break
+			return hec.Event{}, io.EOF
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error reading CSV at line %d: %w", lineNum, err)
+			s.line = parseErrorLine(err, s.line)
+			return hec.Event{}, fmt.Errorf("error reading CSV at line %d: %w", s.line, err)
 		}
+		s.line, _ = s.csvReader.FieldPos(0)
+		s.rows++
 
 		// Transform the record
-		event, err := t.transformRecord(record, header, headerIndices, timeIndex, excludeFields)
+		var event hec.Event
+		if s.t.config.RawMode {
+			event, err = s.t.transformRawRecord(record, s.headerIndices, s.timeIndex)
+		} else {
+			event, err = s.t.transformRecord(record, s.header, s.headerIndices, s.timeIndex, s.excludeFields, s.indexedFields)
+		}
 		if err != nil {
-			if t.config.DiscardInvalid {
+			if s.t.config.DiscardInvalid {
 				// Skip this record if it's invalid and we're configured to discard
 				continue
 			}
-			return nil, fmt.Errorf("error transforming record at line %d: %w", lineNum, err)
+			return hec.Event{}, fmt.Errorf("error transforming record at line %d: %w", s.line, err)
+		}
+
+		// Apply time offset if specified. The raw text is sent as it was, so
+		// only the event time moves in raw mode.
+		if s.t.config.TimeOffset != 0 {
+			if s.t.config.RawMode {
+				if event.Time != nil {
+					*event.Time += int64(s.t.config.TimeOffset.Seconds())
+				}
+			} else {
+				applyTimeOffsets(&event, s.t.config.TimeOffset)
+			}
 		}
 
-		// Apply time offset if specified
-		if t.config.TimeOffset != 0 {
-			applyTimeOffsets(&event, t.config.TimeOffset)
+		return event, nil
+	}
+}
+
+// parseErrorLine returns the line a CSV read error starts at, or line if it doesn't say
+func parseErrorLine(err error, line int) int {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine
+	}
+	return line
+}
+
+// Line returns the line number in the CSV where the last returned event started
+func (s *EventStream) Line() int {
+	return s.line
+}
+
+// Row returns the number of data rows read so far, which is the 1-based row
+// of the last returned event
+func (s *EventStream) Row() int {
+	return s.rows
+}
+
+// SkipRows reads past the next n data rows without transforming them
+func (s *EventStream) SkipRows(ctx context.Context, n int) error {
+	for i := 0; i < n; i++ {
+		if i%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 		}
 
-		events = append(events, event)
+		if _, err := s.csvReader.Read(); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("CSV has %d rows, fewer than the %d to skip", s.rows, n)
+			}
+			s.line = parseErrorLine(err, s.line)
+			return fmt.Errorf("error reading CSV at line %d: %w", s.line, err)
+		}
+		s.line, _ = s.csvReader.FieldPos(0)
+		s.rows++
+	}
+	return nil
+}
+
+// TransformCSV reads a CSV file and returns Splunk events
+func (t *Transformer) TransformCSV(reader io.Reader) ([]hec.Event, error) {
+	stream, err := t.NewEventStream(reader)
+	if err != nil {
+		return nil, err
 	}
 
-	return events, nil
+	var events []hec.Event
+	for {
+		event, err := stream.Next(context.Background())
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
 }
 
 var (
@@ -196,7 +365,7 @@ func applyTimeOffsets(event *hec.Event, offset time.Duration) {
 
 // transformRecord converts a single CSV record to a Splunk event
 func (t *Transformer) transformRecord(record []string, header []string, headerIndices map[string]int,
-	timeIndex int, excludeFields map[string]bool,
+	timeIndex int, excludeFields, indexedFields map[string]bool,
 ) (hec.Event, error) {
 	event := hec.Event{
 		SourceType: t.config.SourceType,
@@ -207,26 +376,11 @@ func (t *Transformer) transformRecord(record []string, header []string, headerIn
 	}
 
 	// Process timestamp if specified
-	if timeIndex >= 0 && timeIndex < len(record) {
-		timestamp, err := t.parseTimestamp(record[timeIndex])
-		if err != nil {
-			fmt.Printf("error parsing timestamp: %v", err)
-			if t.config.DiscardInvalid {
-				return event, fmt.Errorf("invalid timestamp: %w", err)
-			}
-			// Use default timestamp if available
-			if t.config.DefaultTimestamp != nil {
-				unixTime := t.config.DefaultTimestamp.Unix()
-				event.Time = &unixTime
-			}
-		} else {
-			event.Time = &timestamp
-		}
-	} else if t.config.DefaultTimestamp != nil {
-		// Use default timestamp if no time field or out of range
-		unixTime := t.config.DefaultTimestamp.Unix()
-		event.Time = &unixTime
+	eventTime, err := t.eventTime(record, timeIndex)
+	if err != nil {
+		return event, err
 	}
+	event.Time = eventTime
 
 	rawIsJSON := false
 
@@ -252,6 +406,22 @@ func (t *Transformer) transformRecord(record []string, header []string, headerIn
 
 		fieldName := header[i]
 
+		// Skip excluded fields, which aren't sent as indexed fields either
+		if excludeFields[fieldName] {
+			continue
+		}
+
+		// Send chosen columns as indexed fields, whatever the event body
+		// holds. Metadata columns overridden by the config can still be.
+		if indexedFields[fieldName] && fieldName != t.config.TimeField {
+			if value != "" || t.config.PreserveNulls {
+				addIndexedField(&event, t.config.mappedName(fieldName), value)
+			}
+			if !t.config.KeepIndexed {
+				continue
+			}
+		}
+
 		switch fieldName {
 		case t.config.TimeField:
 			// Skip time field since we already processed it
@@ -294,11 +464,6 @@ func (t *Transformer) transformRecord(record []string, header []string, headerIn
 			continue
 		}
 
-		// Skip excluded fields
-		if excludeFields[fieldName] {
-			continue
-		}
-
 		// Skip time field since we already processed it
 		if i == timeIndex {
 			continue
@@ -323,12 +488,90 @@ func (t *Transformer) transformRecord(record []string, header []string, headerIn
 
 	// Add constant fields
 	for key, value := range t.config.ConstantFields {
+		if indexedFields[key] {
+			addIndexedField(&event, key, value)
+			if !t.config.KeepIndexed {
+				continue
+			}
+		}
 		event.Event[key] = value
 	}
 
 	return event, nil
 }
 
+// addIndexedField adds an indexed field to an event
+func addIndexedField(event *hec.Event, name, value string) {
+	if event.Fields == nil {
+		event.Fields = make(map[string]any)
+	}
+	event.Fields[name] = value
+}
+
+// transformRawRecord converts a single CSV record to an event for the raw
+// endpoint. The event holds only the original _raw text; host, source and
+// index come from the record's own columns unless configured.
+func (t *Transformer) transformRawRecord(record []string, headerIndices map[string]int, timeIndex int) (hec.Event, error) {
+	column := func(name string) string {
+		if i, ok := headerIndices[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	event := hec.Event{
+		SourceType: t.config.SourceType,
+		Host:       t.config.Host,
+		Source:     t.config.Source,
+		Index:      t.config.Index,
+		Event:      map[string]any{hec.RawField: column(hec.RawField)},
+	}
+
+	// Take the original metadata from the CSV where it wasn't overridden
+	if event.Host == "" {
+		event.Host = column("host")
+	}
+	if event.Source == "" {
+		event.Source = column("source")
+	}
+	if event.Index == "" {
+		event.Index = column("index")
+	}
+	if event.SourceType == "" {
+		event.SourceType = column("sourcetype")
+	}
+
+	eventTime, err := t.eventTime(record, timeIndex)
+	if err != nil {
+		return event, err
+	}
+	event.Time = eventTime
+
+	return event, nil
+}
+
+// eventTime returns the timestamp of a record, falling back to the default
+// timestamp if it has none or it can't be parsed
+func (t *Transformer) eventTime(record []string, timeIndex int) (*int64, error) {
+	if timeIndex >= 0 && timeIndex < len(record) {
+		timestamp, err := t.parseTimestamp(record[timeIndex])
+		if err == nil {
+			return &timestamp, nil
+		}
+		display.Warnf("error parsing timestamp: %v\n", err)
+		if t.config.DiscardInvalid {
+			return nil, fmt.Errorf("invalid timestamp: %w", err)
+		}
+	}
+
+	// Use default timestamp if available
+	if t.config.DefaultTimestamp != nil {
+		unixTime := t.config.DefaultTimestamp.Unix()
+		return &unixTime, nil
+	}
+	return nil, nil
+}
+
 // parseTimestamp converts a string timestamp to epoch seconds
 func (t *Transformer) parseTimestamp(timeStr string) (int64, error) {
 	// Handle empty timestamp
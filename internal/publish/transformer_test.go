@@ -0,0 +1,269 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// transformCSV returns the first event of a CSV transformed with config
+func transformCSV(t *testing.T, config *TransformerConfig, csv string) (map[string]any, map[string]any) {
+	t.Helper()
+	stream, err := NewTransformer(config).NewEventStream(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event.Event, event.Fields
+}
+
+func TestIndexedFields(t *testing.T) {
+	const csv = "_time,user,action,secret\n1700000000,alice,login,hunter2\n"
+	tests := []struct {
+		name       string
+		config     TransformerConfig
+		wantEvent  string
+		wantFields string
+	}{
+		{
+			name:       "moved out of the event",
+			config:     TransformerConfig{IndexedFields: []string{"user"}},
+			wantEvent:  "map[action:login secret:hunter2]",
+			wantFields: "map[user:alice]",
+		},
+		{
+			name:       "kept in the event",
+			config:     TransformerConfig{IndexedFields: []string{"user"}, KeepIndexed: true},
+			wantEvent:  "map[action:login secret:hunter2 user:alice]",
+			wantFields: "map[user:alice]",
+		},
+		{
+			name:       "sent under the mapped name",
+			config:     TransformerConfig{IndexedFields: []string{"user"}, FieldMappings: map[string]string{"user": "src_user"}},
+			wantEvent:  "map[action:login secret:hunter2]",
+			wantFields: "map[src_user:alice]",
+		},
+		{
+			name:       "constant field",
+			config:     TransformerConfig{IndexedFields: []string{"env"}, ConstantFields: map[string]string{"env": "prod"}},
+			wantEvent:  "map[action:login secret:hunter2 user:alice]",
+			wantFields: "map[env:prod]",
+		},
+		{
+			name:       "excluded column isn't indexed",
+			config:     TransformerConfig{IndexedFields: []string{"user", "secret"}, ExcludeFields: []string{"secret"}},
+			wantEvent:  "map[action:login]",
+			wantFields: "map[user:alice]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.TimeField = "_time"
+			tt.config.TimeFormat = "epoch"
+			event, fields := transformCSV(t, &tt.config, csv)
+			if got := fmt.Sprint(event); got != tt.wantEvent {
+				t.Errorf("got event %s, want %s", got, tt.wantEvent)
+			}
+			if got := fmt.Sprint(fields); got != tt.wantFields {
+				t.Errorf("got indexed fields %s, want %s", got, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestFieldMappings(t *testing.T) {
+	const csv = "_time,src,dst,note\n1700000000,10.0.0.1,10.0.0.2,\n"
+	tests := []struct {
+		name   string
+		config TransformerConfig
+		want   string
+	}{
+		{
+			name:   "renamed",
+			config: TransformerConfig{FieldMappings: map[string]string{"src": "src_ip"}},
+			want:   "map[dst:10.0.0.2 src_ip:10.0.0.1]",
+		},
+		{
+			name:   "strict keeps only renamed fields",
+			config: TransformerConfig{FieldMappings: map[string]string{"src": "src_ip"}, StrictMapping: true},
+			want:   "map[src_ip:10.0.0.1]",
+		},
+		{
+			name:   "constant fields added",
+			config: TransformerConfig{ConstantFields: map[string]string{"env": "lab"}},
+			want:   "map[dst:10.0.0.2 env:lab src:10.0.0.1]",
+		},
+		{
+			name:   "empty values kept",
+			config: TransformerConfig{PreserveNulls: true},
+			want:   "map[dst:10.0.0.2 note: src:10.0.0.1]",
+		},
+		{
+			name:   "excluded before renaming",
+			config: TransformerConfig{ExcludeFields: []string{"src"}, FieldMappings: map[string]string{"src": "src_ip"}},
+			want:   "map[dst:10.0.0.2]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.TimeField = "_time"
+			tt.config.TimeFormat = "epoch"
+			event, _ := transformCSV(t, &tt.config, csv)
+			if got := fmt.Sprint(event); got != tt.want {
+				t.Errorf("got event %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateIndexedFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  TransformerConfig
+		wantErr string
+	}{
+		{
+			name:   "valid column and constant",
+			config: TransformerConfig{IndexedFields: []string{"user", "env"}, ConstantFields: map[string]string{"env": "prod"}},
+		},
+		{
+			name:    "reserved column name",
+			config:  TransformerConfig{IndexedFields: []string{"host"}},
+			wantErr: "invalid indexed field name 'host': it is a default field",
+		},
+		{
+			name:    "mapped to an invalid name",
+			config:  TransformerConfig{IndexedFields: []string{"user"}, FieldMappings: map[string]string{"user": "user-name"}},
+			wantErr: "indexed field 'user' is renamed by the field mappings: invalid indexed field name 'user-name'",
+		},
+		{
+			name:    "mapped to a reserved name",
+			config:  TransformerConfig{IndexedFields: []string{"server"}, FieldMappings: map[string]string{"server": "source"}},
+			wantErr: "indexed field 'server' is renamed by the field mappings: invalid indexed field name 'source': it is a default field",
+		},
+		{
+			name:   "invalid column name mapped to a valid one",
+			config: TransformerConfig{IndexedFields: []string{"_user"}, FieldMappings: map[string]string{"_user": "user"}},
+		},
+		{
+			name:    "two fields sent under one name",
+			config:  TransformerConfig{IndexedFields: []string{"user", "login"}, FieldMappings: map[string]string{"login": "user"}},
+			wantErr: "indexed fields 'user' and 'login' are both sent as 'user'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.ValidateIndexedFields()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	const csv = "_time,message\n" +
+		"1700000001,first\n" +
+		"1700000002,\"second\nspans two lines\"\n" +
+		"bad,discarded\n" +
+		"1700000004,fourth\n"
+	config := &TransformerConfig{TimeField: "_time", TimeFormat: "epoch", DiscardInvalid: true}
+
+	tests := []struct {
+		name string
+		skip int
+		want []string // Message, line and row of each event
+	}{
+		{
+			name: "every row",
+			want: []string{"first 2 1", "second\nspans two lines 3 2", "fourth 6 4"},
+		},
+		{
+			name: "resumed after two rows",
+			skip: 2,
+			want: []string{"fourth 6 4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := NewTransformer(config).NewEventStream(strings.NewReader(csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			if err := stream.SkipRows(ctx, tt.skip); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for {
+				event, err := stream.Next(ctx)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, fmt.Sprintf("%s %d %d", event.Event["message"], stream.Line(), stream.Row()))
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("got events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEventStreamIsIncremental checks that events come out as rows arrive,
+// before the rest of the CSV has been written
+func TestEventStreamIsIncremental(t *testing.T) {
+	reader, writer := io.Pipe()
+	go func() {
+		fmt.Fprint(writer, "_time,message\n1700000001,first\n")
+	}()
+
+	stream, err := NewTransformer(&TransformerConfig{TimeField: "_time", TimeFormat: "epoch"}).NewEventStream(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Event["message"] != "first" {
+		t.Errorf("got event %v, want the first row", event.Event)
+	}
+
+	// A cancelled stream stops without reading further
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := stream.Next(ctx); err != context.Canceled {
+		t.Errorf("got %v from a cancelled stream, want %v", err, context.Canceled)
+	}
+	writer.Close()
+}
+
+func TestSkipRowsPastEnd(t *testing.T) {
+	stream, err := NewTransformer(&TransformerConfig{}).NewEventStream(strings.NewReader("message\na\nb\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.SkipRows(context.Background(), 3)
+	if err == nil || !strings.Contains(err.Error(), "CSV has 2 rows, fewer than the 3 to skip") {
+		t.Errorf("got error %v, want the CSV to be too short", err)
+	}
+}
@@ -0,0 +1,64 @@
+package split
+
+import "math/bits"
+
+// columnSet tracks which columns have held a non-empty value for a single
+// sourcetype, using one bit per column
+type columnSet struct {
+	bits      []uint64
+	width     int
+	remaining int // Columns not yet seen with a value
+}
+
+// newColumnSet creates an empty set for a header of the given width
+func newColumnSet(width int) *columnSet {
+	return &columnSet{
+		bits:      make([]uint64, (width+63)/64),
+		width:     width,
+		remaining: width,
+	}
+}
+
+// markRecord marks every non-empty column in the record as used. Once all
+// columns are known to be used, further records are skipped entirely.
+func (c *columnSet) markRecord(record []string) {
+	if c.remaining == 0 {
+		return
+	}
+
+	if len(record) > c.width {
+		// Values beyond the header have no column name to write them under
+		record = record[:c.width]
+	}
+
+	for i, value := range record {
+		if value == "" {
+			continue
+		}
+		word, mask := i>>6, uint64(1)<<(i&63)
+		if c.bits[word]&mask == 0 {
+			c.bits[word] |= mask
+			c.remaining--
+			if c.remaining == 0 {
+				return
+			}
+		}
+	}
+}
+
+// has reports whether column i has held a value
+func (c *columnSet) has(i int) bool {
+	if i < 0 || i >= c.width {
+		return false
+	}
+	return c.bits[i>>6]&(uint64(1)<<(i&63)) != 0
+}
+
+// count returns the number of used columns
+func (c *columnSet) count() int {
+	n := 0
+	for _, word := range c.bits {
+		n += bits.OnesCount64(word)
+	}
+	return n
+}
@@ -16,14 +16,77 @@ const (
 	defaultRecWidth = 15 // Default records column width
 )
 
-// UpdateDisplay updates the terminal display with current stats
+// UpdateDisplay reports the current stats until done is closed, using the active display mode
 func UpdateDisplay(stats *Stats, done <-chan struct{}) {
+	switch display.CurrentMode() {
+	case display.ModeQuiet:
+		<-done
+	case display.ModePlain, display.ModeJSON:
+		updateLines(stats, done)
+	default:
+		updateTerminal(stats, done)
+	}
+}
+
+// updateLines prints a progress line (or JSON event) at a fixed interval
+func updateLines(stats *Stats, done <-chan struct{}) {
+	ticker := time.NewTicker(display.DefaultLineUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			phase := stats.GetProcessingPhase()
+			progress := stats.GetPhaseProgress()
+			order, records := stats.GetStats()
+
+			totalRecords := 0
+			for _, st := range order {
+				totalRecords += records[st]
+			}
+
+			if display.CurrentMode() == display.ModeJSON {
+				// Leave the ETA null until there's enough data to estimate it
+				var etaSeconds any
+				if eta := progress.ETA(); eta >= 0 {
+					etaSeconds = eta.Seconds()
+				}
+				display.EmitJSON("progress", map[string]any{
+					"command":            "split",
+					"phase":              phase,
+					"percent":            progress.Percent(),
+					"bytes_read":         progress.BytesRead,
+					"total_bytes":        progress.TotalBytes,
+					"bytes_per_second":   progress.BytesPerSecond(),
+					"records_per_second": progress.RecordsPerSecond(),
+					"eta_seconds":        etaSeconds,
+					"records":            totalRecords,
+					"sourcetypes":        records,
+				})
+				continue
+			}
+
+			line := fmt.Sprintf("[%s] %s", phase, time.Now().Format(time.TimeOnly))
+			if phase == "analyzing" || phase == "processing" {
+				line += " " + FormatPhaseProgress(progress)
+			}
+			display.Printf("%s | %d records written across %d sourcetypes\n", line, totalRecords, len(order))
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// updateTerminal redraws a live full-screen display of the current stats
+func updateTerminal(stats *Stats, done <-chan struct{}) {
 	// Set up styles
 	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFFF88")).MarginBottom(1)
 	headerStyle := lipgloss.NewStyle().Bold(true).Underline(true).Foreground(lipgloss.Color("#FFFFFF"))
 	sourceTypeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#88AAFF"))
 	recordsStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#88FF88"))
 	phaseStyle := lipgloss.NewStyle().Italic(true).Foreground(lipgloss.Color("#FFAA44"))
+	progressStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#88FF88"))
 
 	// Create a spinner
 	nextSpinner := display.CreateSpinner(nil)
@@ -80,6 +143,11 @@ func UpdateDisplay(stats *Stats, done <-chan struct{}) {
 			}
 
 			fmt.Println(phaseStr)
+
+			// Show how far through the input file the current pass is
+			if phase == "analyzing" || phase == "processing" {
+				fmt.Println(progressStyle.Render(FormatPhaseProgress(stats.GetPhaseProgress())))
+			}
 			fmt.Println()
 
 			// Format the header
@@ -118,3 +186,19 @@ func UpdateDisplay(stats *Stats, done <-chan struct{}) {
 		}
 	}
 }
+
+// FormatPhaseProgress formats a one-line summary of a phase's percent
+// complete, throughput and ETA
+func FormatPhaseProgress(p PhaseProgress) string {
+	line := fmt.Sprintf("%.1f%% of %s | %.1f MB/s | %.0f rows/s",
+		p.Percent(),
+		display.FormatBytes(p.TotalBytes),
+		p.BytesPerSecond()/(1024*1024),
+		p.RecordsPerSecond())
+	if p.BytesRead < p.TotalBytes {
+		line += " | ETA " + display.FormatETA(p.ETA())
+	} else {
+		line += " | took " + p.Elapsed.Round(100*time.Millisecond).String()
+	}
+	return line
+}
@@ -6,10 +6,11 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+
+	"github.com/thezmc/spexma/internal/common/display"
 )
 
 const (
@@ -21,8 +22,8 @@ var splunkInternalFields = map[string]struct{}{
 	"linecount": {},
 }
 
-// ProcessCSV processes the CSV file
-func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sync.WaitGroup) error {
+// ProcessCSV processes the CSV file, writing one output per sourcetype to the sink
+func ProcessCSV(inputFile string, sink Sink, sourcetypeCol string, stats *Stats, wg *sync.WaitGroup) error {
 	// Open the input file
 	file, err := os.Open(inputFile)
 	if err != nil {
@@ -30,6 +31,11 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 	}
 	defer file.Close()
 
+	// Record the input size for percent complete and ETA
+	if info, err := file.Stat(); err == nil {
+		stats.SetInputSize(info.Size())
+	}
+
 	// Create a buffered reader
 	reader := bufio.NewReader(file)
 	csvReader := csv.NewReader(reader)
@@ -52,11 +58,14 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 		return fmt.Errorf("sourcetype column '%s' not found in header", sourcetypeCol)
 	}
 
-	// Create a map to store the channels for each sourcetype
-	recordChannels := make(map[string]chan []string)
+	// Create a map to store the channel and row pool for each sourcetype
+	writers := make(map[string]*sourcetypeWriter)
+
+	// Track writer goroutines separately so the error channel can be closed once they finish
+	var writerWg sync.WaitGroup
 
 	// Create a map to track non-empty columns for each sourcetype
-	columnUsage := make(map[string]map[int]bool)
+	columnUsage := make(map[string]*columnSet)
 
 	// Create a channel to collect errors from writer goroutines
 	errorChan := make(chan error, 100)
@@ -77,15 +86,16 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 	}()
 
 	// First pass: analyze CSV to determine which columns are used for each sourcetype
-	fmt.Println("Pass 1: Analyzing column usage by sourcetype...")
+	display.Println("Pass 1: Analyzing column usage by sourcetype...")
 	stats.SetProcessingPhase("analyzing")
 
 	// Reset the file for the first pass
 	if _, err := file.Seek(0, 0); err != nil {
 		return fmt.Errorf("error resetting file: %w", err)
 	}
-	reader = bufio.NewReader(file)
+	reader = bufio.NewReader(stats.TrackReader(file))
 	csvReader = csv.NewReader(reader)
+	csvReader.ReuseRecord = true // Records are never retained past the next Read
 
 	// Skip header
 	if _, err := csvReader.Read(); err != nil {
@@ -99,7 +109,7 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 			break
 		}
 		if err != nil {
-			fmt.Printf("Warning: Error reading record during analysis: %v\n", err)
+			display.Warnf("Error reading record during analysis: %v\n", err)
 			continue
 		}
 
@@ -116,17 +126,15 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 			sourcetype = "unknown"
 		}
 
-		// Initialize column usage map for this sourcetype if needed
-		if _, exists := columnUsage[sourcetype]; !exists {
-			columnUsage[sourcetype] = make(map[int]bool)
+		// Initialize column usage for this sourcetype if needed
+		usage, exists := columnUsage[sourcetype]
+		if !exists {
+			usage = newColumnSet(len(header))
+			columnUsage[sourcetype] = usage
 		}
 
 		// Mark columns that are non-empty
-		for i, value := range record {
-			if value != "" {
-				columnUsage[sourcetype][i] = true
-			}
-		}
+		usage.markRecord(record)
 	}
 
 	// Create sourcetype-specific header maps
@@ -134,11 +142,11 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 	sourcetypeHeaderIdx := make(map[string][]int)
 
 	for sourcetype, usedColumns := range columnUsage {
-		var headerList []string
-		var idxList []int
+		headerList := make([]string, 0, usedColumns.count())
+		idxList := make([]int, 0, usedColumns.count())
 
 		for i, colName := range header {
-			if usedColumns[i] {
+			if usedColumns.has(i) {
 				headerList = append(headerList, colName)
 				idxList = append(idxList, i)
 			}
@@ -148,21 +156,22 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 		sourcetypeHeaderIdx[sourcetype] = idxList
 	}
 
+	display.Println("Pass 2: Processing records...")
+	stats.SetProcessingPhase("processing")
+
 	// Reset the file for the second pass
 	if _, err := file.Seek(0, 0); err != nil {
 		return fmt.Errorf("error resetting file: %w", err)
 	}
-	reader = bufio.NewReader(file)
+	reader = bufio.NewReader(stats.TrackReader(file))
 	csvReader = csv.NewReader(reader)
+	csvReader.ReuseRecord = true // Records are never retained past the next Read
 
 	// Skip header again
 	if _, err := csvReader.Read(); err != nil {
 		return fmt.Errorf("error re-reading header: %w", err)
 	}
 
-	fmt.Println("Pass 2: Processing records...")
-	stats.SetProcessingPhase("processing")
-
 	// Process each record
 	for {
 		record, err := csvReader.Read()
@@ -170,13 +179,15 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 			break
 		}
 		if err != nil {
-			fmt.Printf("Warning: Error reading record: %v\n", err)
+			display.Warnf("Error reading record: %v\n", err)
 			continue
 		}
 
+		stats.IncrementReadRecords()
+
 		// Skip records that don't have enough fields
 		if len(record) <= sourcetypeIdx {
-			fmt.Printf("Warning: Record has insufficient fields: %v\n", record)
+			display.Warnf("Record has insufficient fields: %v\n", record)
 			continue
 		}
 
@@ -187,49 +198,41 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 		}
 
 		// Create a new channel and writer for this sourcetype if it doesn't exist
-		if _, exists := recordChannels[sourcetype]; !exists {
-			recordChannels[sourcetype] = make(chan []string, bufferSize)
+		w, exists := writers[sourcetype]
+		if !exists {
+			w = &sourcetypeWriter{
+				ch:      make(chan *row, bufferSize),
+				rows:    newRowPool(sourcetypeHeaderIdx[sourcetype]),
+				counter: stats.Counter(sourcetype),
+			}
+			writers[sourcetype] = w
 
-			// Create the output file with sanitized filename
-			sanitizedSourcetype := sanitizeFilename(sourcetype)
-			outputFile := filepath.Join(outputDir, sanitizedSourcetype+".csv")
+			outputName := OutputName(sourcetype)
 
-			// Start a goroutine to write to this file
+			// Start a goroutine to write to this output
 			wg.Add(1)
-			go func(st string, ch chan []string, headerCols []string, colIndices []int) {
+			writerWg.Add(1)
+			go func(w *sourcetypeWriter, headerCols []string) {
 				defer wg.Done()
+				defer writerWg.Done()
 
-				if err := writeCSV(outputFile, headerCols, ch, stats, st, colIndices); err != nil {
-					errorChan <- fmt.Errorf("error writing to %s: %w", outputFile, err)
+				if err := writeCSV(sink, outputName, headerCols, w); err != nil {
+					errorChan <- fmt.Errorf("error writing to %s: %w", sink.Location(outputName), err)
 				}
-			}(sourcetype, recordChannels[sourcetype], sourcetypeHeaders[sourcetype], sourcetypeHeaderIdx[sourcetype])
+			}(w, sourcetypeHeaders[sourcetype])
 		}
 
-		// Send the record to the appropriate channel
-		select {
-		case recordChannels[sourcetype] <- record:
-			// Record sent successfully
-		default:
-			// Channel buffer is full, write synchronously
-			recordChannels[sourcetype] <- record
-		}
+		// Project the record into a pooled row and send it to the writer
+		w.ch <- w.rows.project(record)
 	}
 
 	// Close all channels to signal writers to finish
-	for _, ch := range recordChannels {
-		close(ch)
+	for _, w := range writers {
+		close(w.ch)
 	}
 
 	// Close the error channel once all writers are done
 	go func() {
-		// We need a separate WaitGroup to avoid deadlock with the main one
-		var writerWg sync.WaitGroup
-		writerWg.Add(len(recordChannels))
-
-		for range recordChannels {
-			writerWg.Done()
-		}
-
 		writerWg.Wait()
 		close(errorChan)
 	}()
@@ -240,58 +243,83 @@ func ProcessCSV(inputFile, outputDir, sourcetypeCol string, stats *Stats, wg *sy
 	return processingErr
 }
 
-// Write records to a CSV file with filtered columns
-func writeCSV(filename string, header []string, records <-chan []string, stats *Stats,
-	sourcetype string, colIndices []int,
-) error {
-	// Create the output file (truncates if it exists)
-	file, err := os.Create(filename)
+// sourcetypeWriter is the reader's handle on a sourcetype's writer goroutine
+type sourcetypeWriter struct {
+	ch      chan *row
+	rows    *rowPool
+	counter *Counter // The sourcetype's record count, held so counting is a single atomic add
+}
+
+// Write projected rows to a sink output as CSV
+func writeCSV(sink Sink, name string, header []string, w *sourcetypeWriter) error {
+	// Create the output (replaces any existing output with the same name)
+	output, err := sink.Create(name)
 	if err != nil {
-		return fmt.Errorf("error creating file: %w", err)
+		return err
 	}
-	defer file.Close()
 
+	if err := writeRecords(output, header, w); err != nil {
+		// Keep draining so the reader is never blocked on this sourcetype
+		for r := range w.ch {
+			w.rows.put(r)
+		}
+		output.Abort(err)
+		return err
+	}
+
+	return output.Close()
+}
+
+// writeRecords writes the header and projected rows to out
+func writeRecords(out io.Writer, header []string, w *sourcetypeWriter) error {
 	// Create a buffered writer
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
+	writer := bufio.NewWriter(out)
 
 	// Create a CSV writer
 	csvWriter := csv.NewWriter(writer)
-	defer csvWriter.Flush()
 
 	// Write the filtered header
 	if err := csvWriter.Write(header); err != nil {
 		return fmt.Errorf("error writing header: %w", err)
 	}
 
-	// Write each record with only the relevant columns
-	for record := range records {
-		// Filter the record to include only the specified columns
-		var filteredRecord []string
-		for _, idx := range colIndices {
-			if idx < len(record) {
-				filteredRecord = append(filteredRecord, record[idx])
-			} else {
-				filteredRecord = append(filteredRecord, "")
-			}
-		}
+	// Count records locally for flush decisions and in the shared stats for display
+	written := 0
 
-		if err := csvWriter.Write(filteredRecord); err != nil {
+	// Write each projected row, returning it to the pool once written
+	for r := range w.ch {
+		err := csvWriter.Write(r.fields)
+		w.rows.put(r)
+		if err != nil {
 			return fmt.Errorf("error writing record: %w", err)
 		}
 
-		stats.IncrementRecord(sourcetype)
+		w.counter.Add(1)
+		written++
 
 		// Flush periodically to ensure data is written
-		if _, countsMap := stats.GetStats(); countsMap[sourcetype]%1000 == 0 {
+		if written%1000 == 0 {
 			csvWriter.Flush()
 			writer.Flush()
 		}
 	}
 
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return fmt.Errorf("error writing record: %w", err)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error flushing output: %w", err)
+	}
+
 	return nil
 }
 
+// OutputName returns the name of the output file for a sourcetype
+func OutputName(sourcetype string) string {
+	return sanitizeFilename(sourcetype) + ".csv"
+}
+
 // Sanitize a sourcetype string to create a valid filename
 func sanitizeFilename(name string) string {
 	// Replace characters that are illegal in filenames on various operating systems
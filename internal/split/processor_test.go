@@ -0,0 +1,162 @@
+package split
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thezmc/spexma/internal/common/display"
+)
+
+// writeTestCSV writes a CSV of rows records spread across sourcetypes, with
+// columns fields beyond the sourcetype. Each sourcetype leaves some columns
+// empty, as real exports do.
+func writeTestCSV(tb testing.TB, rows, columns, sourcetypes int) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "export.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	header := []string{"sourcetype"}
+	for c := 0; c < columns; c++ {
+		header = append(header, fmt.Sprintf("field_%d", c))
+	}
+	fmt.Fprintln(w, strings.Join(header, ","))
+
+	record := make([]string, columns+1)
+	for r := 0; r < rows; r++ {
+		st := r % sourcetypes
+		record[0] = fmt.Sprintf("sourcetype_%d", st)
+		for c := 0; c < columns; c++ {
+			if c%sourcetypes == st || c < 4 {
+				record[c+1] = fmt.Sprintf("value_%d_%d", r, c)
+			} else {
+				record[c+1] = ""
+			}
+		}
+		fmt.Fprintln(w, strings.Join(record, ","))
+	}
+	if err := w.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// TestProcessCSVCounts checks that the writers' counts match the rows
+// they write for every sourcetype
+func TestProcessCSVCounts(t *testing.T) {
+	display.SetMode(display.ModeQuiet)
+	const rows, sourcetypes = 5000, 7
+	input := writeTestCSV(t, rows, 12, sourcetypes)
+
+	var wg sync.WaitGroup
+	dir := t.TempDir()
+	stats := NewStats()
+	if err := ProcessCSV(input, NewFileSink(dir), "sourcetype", stats, &wg); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	order, counts := stats.GetStats()
+	if len(order) != sourcetypes {
+		t.Fatalf("got %d sourcetypes, want %d", len(order), sourcetypes)
+	}
+	for _, name := range order {
+		data, err := os.ReadFile(filepath.Join(dir, name+".csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		written := strings.Count(string(data), "\n") - 1 // Less the header
+		if counts[name] != written {
+			t.Errorf("%s: counted %d records, wrote %d", name, counts[name], written)
+		}
+	}
+	if got := stats.GetPhaseProgress().Records; got != rows {
+		t.Errorf("got %d records in total, want %d", got, rows)
+	}
+}
+
+// BenchmarkProcessor runs both passes of ProcessCSV over a generated export
+func BenchmarkProcessor(b *testing.B) {
+	display.SetMode(display.ModeQuiet)
+	input := writeTestCSV(b, 20000, 40, 8)
+	info, err := os.Stat(input)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(info.Size())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		sink := NewFileSink(b.TempDir())
+		if err := ProcessCSV(input, sink, "sourcetype", NewStats(), &wg); err != nil {
+			b.Fatal(err)
+		}
+		wg.Wait()
+	}
+}
+
+// BenchmarkColumnUsage compares the per-sourcetype bitsets of the analysis
+// pass with the map of column indices they replaced, on a wide export
+func BenchmarkColumnUsage(b *testing.B) {
+	const columns, sourcetypes, rows = 2000, 200, 1000
+
+	names := make([]string, sourcetypes)
+	for i := range names {
+		names[i] = fmt.Sprint(i)
+	}
+	records := make([][]string, rows)
+	for r := range records {
+		records[r] = make([]string, columns)
+		for c := r % 7; c < columns; c += 7 {
+			records[r][c] = "x"
+		}
+	}
+
+	b.Run("bitset", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			usage := make(map[string]*columnSet)
+			for r, record := range records {
+				st := names[r%sourcetypes]
+				set, ok := usage[st]
+				if !ok {
+					set = newColumnSet(columns)
+					usage[st] = set
+				}
+				set.markRecord(record)
+			}
+		}
+	})
+
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			usage := make(map[string]map[int]bool)
+			for r, record := range records {
+				st := names[r%sourcetypes]
+				set, ok := usage[st]
+				if !ok {
+					set = make(map[int]bool)
+					usage[st] = set
+				}
+				for c, value := range record {
+					if value != "" {
+						set[c] = true
+					}
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package split
+
+import "sync"
+
+// row is a record projected onto a sourcetype's columns, handed from the
+// reader to that sourcetype's writer
+type row struct {
+	fields []string
+}
+
+// rowPool recycles rows for a single sourcetype. Each row's field slice is
+// preallocated to the sourcetype's projection width, so steady-state
+// processing allocates no per-row slices.
+type rowPool struct {
+	colIndices []int
+	pool       sync.Pool
+}
+
+// newRowPool creates a pool projecting records onto the given column indices
+func newRowPool(colIndices []int) *rowPool {
+	p := &rowPool{colIndices: colIndices}
+	p.pool.New = func() any {
+		return &row{fields: make([]string, len(colIndices))}
+	}
+	return p
+}
+
+// project copies the sourcetype's columns out of record into a pooled row.
+// The record itself may be reused by the reader once this returns.
+func (p *rowPool) project(record []string) *row {
+	r := p.pool.Get().(*row)
+	for i, idx := range p.colIndices {
+		if idx < len(record) {
+			r.fields[i] = record[idx]
+		} else {
+			r.fields[i] = ""
+		}
+	}
+	return r
+}
+
+// put returns a row to the pool once it has been written
+func (p *rowPool) put(r *row) {
+	p.pool.Put(r)
+}
@@ -0,0 +1,201 @@
+package split
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/thezmc/spexma/internal/common/display"
+)
+
+const (
+	// Default size of each multipart upload part. Each open output buffers
+	// one part in memory for as long as it's open, and split keeps an output
+	// open per sourcetype, so this is the memory each sourcetype costs.
+	DefaultS3PartSize = 16 * 1024 * 1024
+
+	// Default limit on the part buffers of all open outputs together
+	DefaultS3MaxMemory = 1024 * 1024 * 1024
+
+	// Limits S3 places on the size of a multipart upload part
+	MinS3PartSize = 5 * 1024 * 1024
+	MaxS3PartSize = 5 * 1024 * 1024 * 1024
+)
+
+// S3SinkOptions configures an S3-compatible output sink
+type S3SinkOptions struct {
+	Endpoint  string // host[:port] of the S3-compatible service
+	Bucket    string // Bucket to write objects to
+	Prefix    string // Key prefix for written objects
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+	PartSize  uint64            // Multipart part size in bytes
+	MaxMemory uint64            // Limit on the part buffers of all open outputs; 0 for no limit
+	Transport http.RoundTripper // Optional custom transport (e.g. for an in-process fake)
+}
+
+// S3Sink streams split output files to an S3-compatible bucket using
+// multipart uploads, so outputs never touch the local disk
+type S3Sink struct {
+	client    *minio.Client
+	bucket    string
+	prefix    string
+	partSize  uint64
+	maxMemory uint64
+
+	mu       sync.Mutex
+	reserved uint64 // Part buffer bytes of the open outputs
+	warned   bool   // Whether going over maxMemory has been reported
+}
+
+// NewS3Sink creates a sink for the configured bucket and checks that it exists
+func NewS3Sink(options *S3SinkOptions) (*S3Sink, error) {
+	if options.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+
+	partSize := options.PartSize
+	if partSize == 0 {
+		partSize = DefaultS3PartSize
+	}
+	if partSize < MinS3PartSize || partSize > MaxS3PartSize {
+		return nil, fmt.Errorf("S3 part size %d is outside the %d to %d bytes S3 allows", partSize, MinS3PartSize, MaxS3PartSize)
+	}
+
+	client, err := minio.New(options.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(options.AccessKey, options.SecretKey, ""),
+		Secure:    options.UseSSL,
+		Region:    options.Region,
+		Transport: options.Transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating S3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(context.Background(), options.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("error checking bucket '%s': %w", options.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket '%s' does not exist", options.Bucket)
+	}
+
+	return &S3Sink{
+		client:    client,
+		bucket:    options.Bucket,
+		prefix:    strings.Trim(options.Prefix, "/"),
+		partSize:  partSize,
+		maxMemory: options.MaxMemory,
+	}, nil
+}
+
+// Create starts a streaming multipart upload for the named object
+func (s *S3Sink) Create(name string) (Output, error) {
+	partSize := s.reserve()
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	output := &s3Output{
+		pw:      pw,
+		cancel:  cancel,
+		done:    make(chan error, 1),
+		release: func() { s.release(partSize) },
+	}
+
+	go func() {
+		_, err := s.client.PutObject(ctx, s.bucket, s.key(name), pr, -1, minio.PutObjectOptions{
+			ContentType: "text/csv",
+			PartSize:    partSize,
+		})
+		// Unblock any pending writes if the upload stopped early
+		pr.CloseWithError(err)
+		output.done <- err
+	}()
+
+	return output, nil
+}
+
+// reserve returns the part size for a new output, and counts its buffer
+// against the memory limit. Outputs get smaller parts as the limit nears,
+// down to the smallest S3 allows. Uploads can't wait for memory, since
+// every output stays open until the input is read, so past that the limit
+// is exceeded with a warning.
+func (s *S3Sink) reserve() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	partSize := s.partSize
+	if s.maxMemory > 0 && s.reserved+partSize > s.maxMemory {
+		partSize = MinS3PartSize
+		if s.reserved < s.maxMemory {
+			partSize = min(max(s.maxMemory-s.reserved, MinS3PartSize), s.partSize)
+		}
+		if s.reserved+partSize > s.maxMemory && !s.warned {
+			s.warned = true
+			display.Warnf("open S3 uploads need more than the %s memory limit, as each buffers at least a %s part\n",
+				display.FormatBytes(int64(s.maxMemory)), display.FormatBytes(MinS3PartSize))
+		}
+	}
+	s.reserved += partSize
+	return partSize
+}
+
+// release returns an output's part buffer to the memory limit
+func (s *S3Sink) release(partSize uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reserved -= partSize
+}
+
+// Location returns the s3:// URL of the named object
+func (s *S3Sink) Location(name string) string {
+	return "s3://" + s.bucket + "/" + s.key(name)
+}
+
+// key returns the object key for a name
+func (s *S3Sink) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return path.Join(s.prefix, name)
+}
+
+// s3Output feeds an in-flight PutObject call through a pipe
+type s3Output struct {
+	pw      *io.PipeWriter
+	cancel  context.CancelFunc
+	done    chan error
+	release func() // Called once the upload has finished with its part buffer
+}
+
+func (o *s3Output) Write(p []byte) (int, error) {
+	return o.pw.Write(p)
+}
+
+// Close ends the stream and waits for the upload to complete
+func (o *s3Output) Close() error {
+	defer o.cancel()
+	o.pw.Close()
+	err := <-o.done
+	o.release()
+	if err != nil {
+		return fmt.Errorf("error uploading object: %w", err)
+	}
+	return nil
+}
+
+// Abort fails the stream so the multipart upload is aborted rather than completed
+func (o *s3Output) Abort(cause error) error {
+	defer o.cancel()
+	o.pw.CloseWithError(cause)
+	<-o.done
+	o.release()
+	return nil
+}
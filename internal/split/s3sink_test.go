@@ -0,0 +1,293 @@
+package split
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an in-process S3 that supports just the calls a multipart upload makes
+type fakeS3 struct {
+	mu        sync.Mutex
+	bucket    string
+	uploads   int
+	parts     map[int][]byte // Parts of the current upload by number
+	completed map[string][]byte
+	aborted   []string
+	failParts bool // Reject every part upload
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, parts: make(map[int][]byte), completed: make(map[string][]byte)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.uploads++
+		f.parts = make(map[int][]byte)
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>upload-%d</UploadId></InitiateMultipartUploadResult>`, bucket, key, f.uploads)
+
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		if f.failParts {
+			f.error(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			f.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			f.error(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 {
+				f.error(w, http.StatusBadRequest, "InvalidPartOrder")
+				return
+			}
+			object = append(object, f.parts[part.PartNumber]...)
+		}
+		f.completed[key] = object
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`, bucket, key)
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.aborted = append(f.aborted, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		f.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+// partSizes returns the size of each part of the last upload in order
+func (f *fakeS3) partSizes() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sizes := make([]int, len(f.parts))
+	for number, part := range f.parts {
+		sizes[number-1] = len(part)
+	}
+	return sizes
+}
+
+// newTestS3Sink starts a fake S3 over TLS, so parts are sent unencoded, and
+// returns a sink writing to it
+func newTestS3Sink(t *testing.T, partSize uint64) (*S3Sink, *fakeS3) {
+	t.Helper()
+	fake := newFakeS3("exports")
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+
+	sink, err := NewS3Sink(&S3SinkOptions{
+		Endpoint:  strings.TrimPrefix(server.URL, "https://"),
+		Bucket:    "exports",
+		Prefix:    "/run1/",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Region:    "us-east-1",
+		UseSSL:    true,
+		PartSize:  partSize,
+		Transport: server.Client().Transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink, fake
+}
+
+func TestS3SinkMultipartUpload(t *testing.T) {
+	sink, fake := newTestS3Sink(t, MinS3PartSize)
+
+	// Two full parts and a short last one
+	data := bytes.Repeat([]byte("host,source,value\n"), (2*MinS3PartSize+1024*1024)/18)
+	output, err := sink.Create("web.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for chunk := data; len(chunk) > 0; {
+		n := min(len(chunk), 64*1024)
+		if _, err := output.Write(chunk[:n]); err != nil {
+			t.Fatal(err)
+		}
+		chunk = chunk[n:]
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := fake.partSizes()
+	want := []int{MinS3PartSize, MinS3PartSize, len(data) - 2*MinS3PartSize}
+	if fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Errorf("got part sizes %v, want %v", sizes, want)
+	}
+	object, ok := fake.completed["run1/web.csv"]
+	if !ok {
+		t.Fatalf("upload of run1/web.csv was not completed; completed %v", fake.completed)
+	}
+	if !bytes.Equal(object, data) {
+		t.Errorf("completed object is %d bytes, want the %d bytes written", len(object), len(data))
+	}
+	if len(fake.aborted) != 0 {
+		t.Errorf("got aborted uploads %v, want none", fake.aborted)
+	}
+	if got := sink.Location("web.csv"); got != "s3://exports/run1/web.csv" {
+		t.Errorf("got location %s", got)
+	}
+	if sink.reserved != 0 {
+		t.Errorf("%d bytes of part buffers still reserved after Close", sink.reserved)
+	}
+}
+
+func TestS3SinkAbort(t *testing.T) {
+	sink, fake := newTestS3Sink(t, MinS3PartSize)
+
+	output, err := sink.Create("web.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := output.Write([]byte("host,source,value\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Abort(errors.New("processing failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.completed) != 0 {
+		t.Errorf("got completed objects %v, want none", fake.completed)
+	}
+	if fmt.Sprint(fake.aborted) != "[run1/web.csv]" {
+		t.Errorf("got aborted uploads %v, want [run1/web.csv]", fake.aborted)
+	}
+	if sink.reserved != 0 {
+		t.Errorf("%d bytes of part buffers still reserved after Abort", sink.reserved)
+	}
+}
+
+func TestS3SinkUploadError(t *testing.T) {
+	sink, fake := newTestS3Sink(t, MinS3PartSize)
+	fake.failParts = true
+
+	output, err := sink.Create("web.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes fail once the upload has stopped, so their error isn't checked
+	output.Write(bytes.Repeat([]byte("x"), MinS3PartSize+1))
+	if err := output.Close(); err == nil {
+		t.Fatal("Close succeeded after the part upload was rejected")
+	}
+
+	if len(fake.completed) != 0 {
+		t.Errorf("got completed objects %v, want none", fake.completed)
+	}
+	if fmt.Sprint(fake.aborted) != "[run1/web.csv]" {
+		t.Errorf("got aborted uploads %v, want [run1/web.csv]", fake.aborted)
+	}
+}
+
+func TestNewS3SinkPartSize(t *testing.T) {
+	sink, _ := newTestS3Sink(t, 0)
+	if sink.partSize != DefaultS3PartSize {
+		t.Errorf("got default part size %d, want %d", sink.partSize, DefaultS3PartSize)
+	}
+
+	for _, size := range []uint64{1024 * 1024, MinS3PartSize - 1, MaxS3PartSize + 1} {
+		_, err := NewS3Sink(&S3SinkOptions{Endpoint: "localhost:9000", Bucket: "exports", PartSize: size})
+		if err == nil {
+			t.Errorf("part size %d was accepted", size)
+		}
+	}
+}
+
+func TestNewS3SinkMissingBucket(t *testing.T) {
+	server := httptest.NewTLSServer(newFakeS3("exports"))
+	defer server.Close()
+
+	_, err := NewS3Sink(&S3SinkOptions{
+		Endpoint:  strings.TrimPrefix(server.URL, "https://"),
+		Bucket:    "missing",
+		Region:    "us-east-1",
+		UseSSL:    true,
+		Transport: server.Client().Transport,
+	})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("got error %v, want a missing bucket error", err)
+	}
+}
+
+// TestS3SinkMemoryLimit checks the part sizes outputs get as they open and
+// close under a memory limit
+func TestS3SinkMemoryLimit(t *testing.T) {
+	const mib = 1024 * 1024
+	tests := []struct {
+		name      string
+		maxMemory uint64
+		opens     int      // Outputs opened before the ones checked
+		closes    []uint64 // Part sizes released after opening them
+		want      []uint64 // Part sizes of the next outputs
+	}{
+		{"no limit", 0, 100, nil, []uint64{16 * mib, 16 * mib}},
+		{"within the limit", 64 * mib, 3, nil, []uint64{16 * mib}},
+		{"smaller part for what's left", 60 * mib, 3, nil, []uint64{12 * mib, MinS3PartSize}},
+		{"never below the S3 minimum", 50 * mib, 3, nil, []uint64{MinS3PartSize, MinS3PartSize}},
+		{"closed outputs free memory", 64 * mib, 4, []uint64{16 * mib}, []uint64{16 * mib, MinS3PartSize}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &S3Sink{partSize: 16 * mib, maxMemory: tt.maxMemory}
+			for i := 0; i < tt.opens; i++ {
+				sink.reserve()
+			}
+			for _, size := range tt.closes {
+				sink.release(size)
+			}
+			for i, want := range tt.want {
+				if got := sink.reserve(); got != want {
+					t.Errorf("output %d: got %d MiB parts, want %d MiB", tt.opens+i, got/mib, want/mib)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package split
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Sink is a destination for split output files
+type Sink interface {
+	// Create opens a named output, replacing any existing output with the same name
+	Create(name string) (Output, error)
+	// Location describes where a named output is written, for display and errors
+	Location(name string) string
+}
+
+// Output is a single split output file being written to a Sink
+type Output interface {
+	io.Writer
+	// Close finalizes the output and reports any error committing it
+	Close() error
+	// Abort discards a partially written output after a failure
+	Abort(cause error) error
+}
+
+// FileSink writes split output files to a local directory
+type FileSink struct {
+	Directory string
+}
+
+// NewFileSink creates a sink that writes to the given directory
+func NewFileSink(directory string) *FileSink {
+	return &FileSink{Directory: directory}
+}
+
+// Create creates (or truncates) a file in the sink's directory
+func (s *FileSink) Create(name string) (Output, error) {
+	path := s.Location(name)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating file: %w", err)
+	}
+	return &fileOutput{file: file, path: path}, nil
+}
+
+// Location returns the path of the named file
+func (s *FileSink) Location(name string) string {
+	return filepath.Join(s.Directory, name)
+}
+
+// fileOutput is an Output backed by a local file
+type fileOutput struct {
+	file *os.File
+	path string
+}
+
+func (o *fileOutput) Write(p []byte) (int, error) {
+	return o.file.Write(p)
+}
+
+func (o *fileOutput) Close() error {
+	return o.file.Close()
+}
+
+// Abort closes and removes the partially written file
+func (o *fileOutput) Abort(cause error) error {
+	o.file.Close()
+	if err := os.Remove(o.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing partial file: %w", err)
+	}
+	return nil
+}
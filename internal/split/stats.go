@@ -1,58 +1,180 @@
 package split
 
 import (
+	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Stats keeps track of the record counts per sourcetype
+// PhaseProgress describes how far a pass over the input file has progressed
+type PhaseProgress struct {
+	Phase      string
+	BytesRead  int64
+	TotalBytes int64
+	Records    int
+	Elapsed    time.Duration
+}
+
+// Percent returns the percentage of the input consumed in this phase
+func (p PhaseProgress) Percent() float64 {
+	if p.TotalBytes <= 0 {
+		return 0
+	}
+	return float64(p.BytesRead) / float64(p.TotalBytes) * 100
+}
+
+// BytesPerSecond returns the input throughput of this phase
+func (p PhaseProgress) BytesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.BytesRead) / p.Elapsed.Seconds()
+}
+
+// RecordsPerSecond returns the record throughput of this phase
+func (p PhaseProgress) RecordsPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Records) / p.Elapsed.Seconds()
+}
+
+// ETA estimates the time remaining in this phase, or -1 if it can't be estimated yet
+func (p PhaseProgress) ETA() time.Duration {
+	rate := p.BytesPerSecond()
+	if rate <= 0 || p.TotalBytes <= 0 {
+		return -1
+	}
+	remaining := p.TotalBytes - p.BytesRead
+	if remaining < 0 {
+		remaining = 0
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second))
+}
+
+// Counter is the record count for a single sourcetype. Writers hold on to
+// their counter so that counting a record is a single atomic add.
+type Counter struct {
+	name  string
+	count atomic.Int64
+}
+
+// Add adds n records to the counter
+func (c *Counter) Add(n int64) {
+	c.count.Add(n)
+}
+
+// Load returns the current count
+func (c *Counter) Load() int64 {
+	return c.count.Load()
+}
+
+// Stats keeps track of the record counts per sourcetype. Counters are
+// updated lock-free; the mutex only guards registering new sourcetypes
+// and phase transitions.
 type Stats struct {
 	mu               sync.RWMutex
-	records          map[string]int
-	order            []string // To maintain order of sourcetypes for display
-	maxSourcetypeLen int      // Track maximum sourcetype length for display
-	analyzedRecords  int      // Track number of records analyzed in first pass
-	processingPhase  string   // Current processing phase
+	counters         map[string]*Counter
+	order            []*Counter // To maintain order of sourcetypes for display
+	maxSourcetypeLen int        // Track maximum sourcetype length for display
+	processingPhase  string     // Current processing phase
+	phaseStart       time.Time
+	phases           []PhaseProgress // Completed phases that read the input
+
+	analyzedRecords atomic.Int64 // Track number of records analyzed in first pass
+	inputSize       atomic.Int64 // Size of the input file in bytes
+	phaseBytes      atomic.Int64 // Bytes of input consumed in the current phase
+	phaseRecords    atomic.Int64 // Records read in the current phase
 }
 
 // NewStats creates a new Stats instance
 func NewStats() *Stats {
 	return &Stats{
-		records:          make(map[string]int),
-		order:            []string{},
+		counters:         make(map[string]*Counter),
+		order:            []*Counter{},
 		maxSourcetypeLen: 20, // Default starting width
 		processingPhase:  "initializing",
+		phaseStart:       time.Now(),
 	}
 }
 
-// IncrementRecord adds a record to the stats
-func (s *Stats) IncrementRecord(sourcetype string) {
+// Counter returns the counter for a sourcetype, registering it if needed
+func (s *Stats) Counter(sourcetype string) *Counter {
+	s.mu.RLock()
+	counter, exists := s.counters[sourcetype]
+	s.mu.RUnlock()
+	if exists {
+		return counter
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.records[sourcetype]; !exists {
-		s.order = append(s.order, sourcetype)
-		s.records[sourcetype] = 0
+	if counter, exists := s.counters[sourcetype]; exists {
+		return counter
+	}
+
+	counter = &Counter{name: sourcetype}
+	s.counters[sourcetype] = counter
+	s.order = append(s.order, counter)
 
-		// Update max sourcetype length if needed
-		if len(sourcetype) > s.maxSourcetypeLen {
-			s.maxSourcetypeLen = len(sourcetype)
-		}
+	// Update max sourcetype length if needed
+	if len(sourcetype) > s.maxSourcetypeLen {
+		s.maxSourcetypeLen = len(sourcetype)
 	}
-	s.records[sourcetype]++
+
+	return counter
+}
+
+// IncrementRecord adds a record to the stats. It looks the counter up on
+// every call; writers counting many records should hold on to Counter's
+// result instead.
+func (s *Stats) IncrementRecord(sourcetype string) {
+	s.Counter(sourcetype).Add(1)
 }
 
 // IncrementAnalyzedRecords increments the count of analyzed records
 func (s *Stats) IncrementAnalyzedRecords() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.analyzedRecords++
+	s.analyzedRecords.Add(1)
+	s.phaseRecords.Add(1)
+}
+
+// IncrementReadRecords increments the count of records read in the current phase
+func (s *Stats) IncrementReadRecords() {
+	s.phaseRecords.Add(1)
+}
+
+// SetInputSize sets the size of the input file used for percent complete and ETA
+func (s *Stats) SetInputSize(size int64) {
+	s.inputSize.Store(size)
+}
+
+// AddBytesRead records bytes of input consumed in the current phase
+func (s *Stats) AddBytesRead(n int64) {
+	s.phaseBytes.Add(n)
 }
 
-// SetProcessingPhase sets the current processing phase
+// TrackReader wraps r so that bytes read from it are counted in the current phase
+func (s *Stats) TrackReader(r io.Reader) io.Reader {
+	return &countingReader{r: r, stats: s}
+}
+
+// SetProcessingPhase sets the current processing phase, resetting the
+// per-phase byte and record counters
 func (s *Stats) SetProcessingPhase(phase string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+
+	// Keep a record of the phase being left if it read any input
+	if s.phaseBytes.Load() > 0 {
+		s.phases = append(s.phases, s.phaseProgressLocked())
+	}
+
 	s.processingPhase = phase
+	s.phaseBytes.Store(0)
+	s.phaseRecords.Store(0)
+	s.phaseStart = time.Now()
 }
 
 // GetProcessingPhase gets the current processing phase
@@ -62,11 +184,37 @@ func (s *Stats) GetProcessingPhase() string {
 	return s.processingPhase
 }
 
-// GetAnalyzedRecords gets the number of analyzed records
-func (s *Stats) GetAnalyzedRecords() int {
+// GetPhaseProgress returns the progress of the current phase
+func (s *Stats) GetPhaseProgress() PhaseProgress {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.phaseProgressLocked()
+}
+
+// GetCompletedPhases returns the progress of each completed phase that read the input
+func (s *Stats) GetCompletedPhases() []PhaseProgress {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.analyzedRecords
+
+	phasesCopy := make([]PhaseProgress, len(s.phases))
+	copy(phasesCopy, s.phases)
+	return phasesCopy
+}
+
+// phaseProgressLocked builds the current phase's progress; s.mu must be held
+func (s *Stats) phaseProgressLocked() PhaseProgress {
+	return PhaseProgress{
+		Phase:      s.processingPhase,
+		BytesRead:  s.phaseBytes.Load(),
+		TotalBytes: s.inputSize.Load(),
+		Records:    int(s.phaseRecords.Load()),
+		Elapsed:    time.Since(s.phaseStart),
+	}
+}
+
+// GetAnalyzedRecords gets the number of analyzed records
+func (s *Stats) GetAnalyzedRecords() int {
+	return int(s.analyzedRecords.Load())
 }
 
 // GetMaxSourcetypeLen gets the maximum sourcetype length
@@ -76,18 +224,32 @@ func (s *Stats) GetMaxSourcetypeLen() int {
 	return s.maxSourcetypeLen
 }
 
-// GetStats returns a copy of the stats for display
+// GetStats returns a snapshot of the stats for display
 func (s *Stats) GetStats() ([]string, map[string]int) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
+	counters := s.order[:len(s.order):len(s.order)]
+	s.mu.RUnlock()
 
-	recordsCopy := make(map[string]int)
-	for k, v := range s.records {
-		recordsCopy[k] = v
+	order := make([]string, len(counters))
+	records := make(map[string]int, len(counters))
+	for i, counter := range counters {
+		order[i] = counter.name
+		records[counter.name] = int(counter.Load())
 	}
 
-	orderCopy := make([]string, len(s.order))
-	copy(orderCopy, s.order)
+	return order, records
+}
+
+// countingReader counts bytes read from the input file
+type countingReader struct {
+	r     io.Reader
+	stats *Stats
+}
 
-	return orderCopy, recordsCopy
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.stats.AddBytesRead(int64(n))
+	}
+	return n, err
 }
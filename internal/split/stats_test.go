@@ -0,0 +1,128 @@
+package split
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestStatsConcurrentCounts counts from many goroutines at once and checks
+// that no records are lost; run it with -race to check the counters too
+func TestStatsConcurrentCounts(t *testing.T) {
+	const workers, records, sourcetypes = 16, 2000, 5
+
+	stats := NewStats()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			held := stats.Counter(fmt.Sprintf("sourcetype_%d", w%sourcetypes))
+			for r := 0; r < records; r++ {
+				stats.IncrementRecord(fmt.Sprintf("sourcetype_%d", r%sourcetypes))
+				held.Add(1)
+				stats.IncrementAnalyzedRecords()
+				stats.IncrementReadRecords()
+				stats.AddBytesRead(10)
+				if r%500 == 0 {
+					stats.GetStats()
+					stats.GetPhaseProgress()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	order, counts := stats.GetStats()
+	if len(order) != sourcetypes {
+		t.Fatalf("got %d sourcetypes, want %d", len(order), sourcetypes)
+	}
+	total := 0
+	for i := 0; i < sourcetypes; i++ {
+		name := fmt.Sprintf("sourcetype_%d", i)
+		want := workers*records/sourcetypes + workers/sourcetypes*records
+		if i < workers%sourcetypes {
+			want += records
+		}
+		if counts[name] != want {
+			t.Errorf("%s: got %d records, want %d", name, counts[name], want)
+		}
+		total += counts[name]
+	}
+	if total != 2*workers*records {
+		t.Errorf("got %d records in total, want %d", total, 2*workers*records)
+	}
+
+	if got := stats.GetAnalyzedRecords(); got != workers*records {
+		t.Errorf("got %d analyzed records, want %d", got, workers*records)
+	}
+	progress := stats.GetPhaseProgress()
+	if progress.Records != 2*workers*records {
+		t.Errorf("got %d phase records, want %d", progress.Records, 2*workers*records)
+	}
+	if progress.BytesRead != int64(workers*records*10) {
+		t.Errorf("got %d phase bytes, want %d", progress.BytesRead, workers*records*10)
+	}
+}
+
+// mutexStats is the map-under-a-mutex counting that Stats used before its
+// per-sourcetype atomic counters, kept to benchmark against
+type mutexStats struct {
+	mu      sync.RWMutex
+	records map[string]int
+	order   []string
+}
+
+func (s *mutexStats) IncrementRecord(sourcetype string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.records[sourcetype]; !exists {
+		s.order = append(s.order, sourcetype)
+	}
+	s.records[sourcetype]++
+}
+
+// BenchmarkStatsIncrement compares counting records from parallel writers.
+// "writer" is how ProcessCSV's writers count: each goroutine holds its own
+// sourcetype's counter, so a record is one uncontended atomic add. "lookup"
+// finds the counter by name for every record, paying a read lock and a map
+// lookup, which can be slower than the mutex the counters replaced.
+func BenchmarkStatsIncrement(b *testing.B) {
+	names := make([]string, 8)
+	for i := range names {
+		names[i] = fmt.Sprintf("sourcetype_%d", i)
+	}
+
+	b.Run("writer", func(b *testing.B) {
+		stats := NewStats()
+		var next atomic.Int64
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			counter := stats.Counter(names[int(next.Add(1))%len(names)])
+			for pb.Next() {
+				counter.Add(1)
+			}
+		})
+	})
+
+	b.Run("lookup", func(b *testing.B) {
+		stats := NewStats()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				stats.IncrementRecord(names[i%len(names)])
+			}
+		})
+	})
+
+	b.Run("mutex", func(b *testing.B) {
+		stats := &mutexStats{records: make(map[string]int)}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				stats.IncrementRecord(names[i%len(names)])
+			}
+		})
+	})
+}
@@ -0,0 +1,498 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/thezmc/spexma/internal/common/summary"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file options
+var (
+	configPath  string
+	profileName string
+)
+
+// Environment variables setting flags are named envPrefix followed by the
+// flag name in upper case, with dashes as underscores (e.g. SPEXMA_BATCH_SIZE)
+const envPrefix = "SPEXMA_"
+
+// requiredAnnotation marks flags that must be set by a flag, environment
+// variable or config file. Cobra's own required flags can't be set by
+// anything but the command line.
+const requiredAnnotation = "spexma_required"
+
+// Where a flag's effective value came from, lowest precedence first
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// exclusiveFlags are groups of flags giving the same setting in different
+// ways. Only the members set with the highest precedence take effect.
+var exclusiveFlags = [][]string{
+	{"token", "token-file", "token-stdin"},
+}
+
+// configFile is the YAML config file. Settings are keyed by flag name and
+// apply to every command with that flag; a setting whose key is a command
+// name holds settings for that command only, which take precedence.
+//
+//	default_profile: lab
+//	defaults:
+//	  batch-size: 500
+//	profiles:
+//	  lab:
+//	    url: https://lab-hec:8088/services/collector
+//	    index: test
+//	    publish:
+//	      exclude-fields: [date_hour, date_mday]
+//	      add-field: {env: lab}
+type configFile struct {
+	DefaultProfile string                    `yaml:"default_profile"`
+	Defaults       map[string]any            `yaml:"defaults"`
+	Profiles       map[string]map[string]any `yaml:"profiles"`
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration file and profiles",
+}
+
+// configShowCmd represents the config show command
+var configShowCmd = &cobra.Command{
+	Use:   "show [command...]",
+	Short: "Print the effective configuration of commands",
+	Long: `Print the effective value of every flag of the given commands (all of them by
+default), merged from the defaults, the config file profile and environment
+variables, along with where each value came from. Secrets are redacted, as are
+passwords in URLs such as --proxy.
+
+Example:
+  spexma config show publish --profile prod`,
+	RunE: runConfigShow,
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+}
+
+// defaultConfigPath returns the config file used when --config isn't given
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "spexma", "config.yaml")
+}
+
+// loadConfig reads the config file named by --config or SPEXMA_CONFIG, or
+// the default one if it exists. It returns nil if there is no config file.
+func loadConfig() (*configFile, string, error) {
+	path := configPath
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	if path == "" {
+		return nil, "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var config configFile
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, "", fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return &config, path, nil
+}
+
+// activeProfile returns the profile named by --profile, SPEXMA_PROFILE or
+// the config file's default_profile, or "" for none
+func activeProfile(config *configFile) string {
+	if profileName != "" {
+		return profileName
+	}
+	if profile := os.Getenv(envPrefix + "PROFILE"); profile != "" {
+		return profile
+	}
+	if config != nil {
+		return config.DefaultProfile
+	}
+	return ""
+}
+
+// settings returns the config file settings for a command, merging the
+// defaults with the profile
+func (c *configFile) settings(profile, command string) (map[string]any, error) {
+	merged := make(map[string]any)
+	if c == nil {
+		if profile != "" {
+			return nil, fmt.Errorf("profile '%s' given but there is no config file", profile)
+		}
+		return merged, nil
+	}
+
+	layers := []map[string]any{c.Defaults}
+	if profile != "" {
+		settings, ok := c.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile '%s' not found in config file", profile)
+		}
+		layers = append(layers, settings)
+	}
+
+	for _, layer := range layers {
+		commandSettings := map[string]any{}
+		for key, value := range layer {
+			if isCommandName(key) {
+				nested, ok := value.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("config setting '%s' must hold the settings of the %s command", key, key)
+				}
+				if key == command {
+					commandSettings = nested
+				}
+				continue
+			}
+			if !isFlagName(key) {
+				return nil, fmt.Errorf("unknown config setting '%s'", key)
+			}
+			merged[key] = value
+		}
+		for key, value := range commandSettings {
+			if !isFlagName(key) {
+				return nil, fmt.Errorf("unknown config setting '%s' for the %s command", key, command)
+			}
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// isCommandName reports whether name is a subcommand of spexma
+func isCommandName(name string) bool {
+	for _, cmd := range rootCmd.Commands() {
+		if cmd.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isFlagName reports whether name is a flag of any command
+func isFlagName(name string) bool {
+	if rootCmd.PersistentFlags().Lookup(name) != nil {
+		return true
+	}
+	for _, cmd := range rootCmd.Commands() {
+		if cmd.Flags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// configurable reports whether a flag can be set from the environment or config file
+func configurable(f *pflag.Flag) bool {
+	switch f.Name {
+	case "help", "version", "config", "profile":
+		return false
+	}
+	return true
+}
+
+// envValue returns the environment variable setting a flag, if set
+func envValue(name string) (string, bool) {
+	return os.LookupEnv(envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_")))
+}
+
+// configValues converts a config file value to values of flag f. A list
+// sets a list flag to its items, and a map sets a key=value flag to its
+// entries.
+func configValues(f *pflag.Flag, value any) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("config setting '%s' has no value", f.Name)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	case map[string]any:
+		if f.Value.Type() != "stringToString" {
+			return nil, fmt.Errorf("config setting '%s' must be a value or a list", f.Name)
+		}
+		return configPairs(f.Name, v)
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+// configPairs converts a config file map to key=value flag values, in key order
+func configPairs(name string, entries map[string]any) ([]string, error) {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		switch entries[key].(type) {
+		case nil, []any, map[string]any:
+			return nil, fmt.Errorf("config setting '%s' must map '%s' to a single value", name, key)
+		}
+		pair := key + "=" + fmt.Sprint(entries[key])
+		if strings.Count(pair, "=") > 1 {
+			// The flag reads a value with several '='s as comma-separated
+			// pairs, so quote it as one
+			pair = `"` + strings.ReplaceAll(pair, `"`, `""`) + `"`
+		}
+		values = append(values, pair)
+	}
+	return values, nil
+}
+
+// shadowed returns the flags that mustn't be set from the environment or
+// config file because another flag of their exclusive group is set with
+// higher precedence. given reports whether a flag was on the command line.
+func shadowed(given func(name string) bool, settings map[string]any) map[string]bool {
+	precedence := func(name string) int {
+		if given(name) {
+			return 3
+		}
+		if _, ok := envValue(name); ok {
+			return 2
+		}
+		if _, ok := settings[name]; ok {
+			return 1
+		}
+		return 0
+	}
+
+	skip := make(map[string]bool)
+	for _, group := range exclusiveFlags {
+		highest := 0
+		for _, name := range group {
+			highest = max(highest, precedence(name))
+		}
+		for _, name := range group {
+			if precedence(name) < highest {
+				skip[name] = true
+			}
+		}
+	}
+	return skip
+}
+
+// applyConfig sets every flag of cmd that wasn't given on the command line
+// from its environment variable or, failing that, the config file. It then
+// checks the required flags are set.
+func applyConfig(cmd *cobra.Command) error {
+	config, _, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	settings, err := config.settings(activeProfile(config), cmd.Name())
+	if err != nil {
+		return err
+	}
+
+	skip := shadowed(cmd.Flags().Changed, settings)
+
+	var applyErr error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if applyErr != nil || f.Changed || !configurable(f) || skip[f.Name] {
+			return
+		}
+
+		var values []string
+		if value, ok := envValue(f.Name); ok {
+			values = []string{value}
+			if f.Value.Type() == "stringArray" {
+				// Unlike slices, arrays don't split their values on commas
+				values = strings.Split(value, ",")
+			}
+		} else if value, ok := settings[f.Name]; ok {
+			if values, err = configValues(f, value); err != nil {
+				applyErr = err
+				return
+			}
+		}
+		for _, value := range values {
+			if err := cmd.Flags().Set(f.Name, value); err != nil {
+				applyErr = fmt.Errorf("invalid value for %s: %w", f.Name, err)
+				return
+			}
+		}
+	})
+	if applyErr != nil {
+		return applyErr
+	}
+
+	return checkRequired(cmd)
+}
+
+// markRequired marks flags of cmd as required
+func markRequired(cmd *cobra.Command, names ...string) {
+	for _, name := range names {
+		cmd.Flags().SetAnnotation(name, requiredAnnotation, []string{"true"})
+	}
+}
+
+// checkRequired returns an error naming any required flags that aren't set
+func checkRequired(cmd *cobra.Command) error {
+	var missing []string
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if _, ok := f.Annotations[requiredAnnotation]; ok && !f.Changed {
+			missing = append(missing, fmt.Sprintf("%q", f.Name))
+		}
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("required flag(s) %s not set", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// effectiveSetting is a flag's value and where it came from
+type effectiveSetting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// effectiveSettings resolves every flag of cmd the way applyConfig would,
+// without setting them. Global flags given to the running command count as
+// given on the command line.
+func effectiveSettings(cmd, running *cobra.Command, settings map[string]any) (map[string]effectiveSetting, error) {
+	effective := make(map[string]effectiveSetting)
+	flags := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+	flags.AddFlagSet(cmd.Flags())
+	flags.AddFlagSet(rootCmd.PersistentFlags())
+
+	given := func(name string) bool {
+		f := running.Flags().Lookup(name)
+		return f != nil && f.Changed
+	}
+	skip := shadowed(given, settings)
+
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || !configurable(f) {
+			return
+		}
+
+		setting := effectiveSetting{Value: f.DefValue, Source: sourceDefault}
+		if given(f.Name) {
+			setting = effectiveSetting{Value: running.Flags().Lookup(f.Name).Value.String(), Source: sourceFlag}
+		} else if value, ok := envValue(f.Name); ok && !skip[f.Name] {
+			setting = effectiveSetting{Value: value, Source: sourceEnv}
+		} else if value, ok := settings[f.Name]; ok && !skip[f.Name] {
+			var values []string
+			if values, err = configValues(f, value); err != nil {
+				return
+			}
+			setting = effectiveSetting{Value: strings.Join(values, ","), Source: sourceFile}
+		}
+
+		setting.Value = summary.RedactValue(f.Name, setting.Value)
+		effective[f.Name] = setting
+	})
+	return effective, err
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	config, path, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	profile := activeProfile(config)
+
+	// Show the commands asked for, or every command that takes flags
+	var commands []*cobra.Command
+	for _, name := range args {
+		found, _, err := rootCmd.Find([]string{name})
+		if err != nil || found == rootCmd {
+			return fmt.Errorf("unknown command '%s'", name)
+		}
+		commands = append(commands, found)
+	}
+	if len(args) == 0 {
+		for _, c := range rootCmd.Commands() {
+			if c.HasAvailableLocalFlags() && c != configCmd {
+				commands = append(commands, c)
+			}
+		}
+	}
+
+	effective := make(map[string]map[string]effectiveSetting)
+	for _, c := range commands {
+		settings, err := config.settings(profile, c.Name())
+		if err != nil {
+			return err
+		}
+		if effective[c.Name()], err = effectiveSettings(c, cmd, settings); err != nil {
+			return err
+		}
+	}
+
+	if outputFormat == "json" {
+		data, err := json.MarshalIndent(map[string]any{
+			"config_file": path,
+			"profile":     profile,
+			"commands":    effective,
+		}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling configuration: %w", err)
+		}
+		os.Stdout.Write(append(data, '\n'))
+		return nil
+	}
+
+	if path == "" {
+		fmt.Println("Config file: none")
+	} else {
+		fmt.Printf("Config file: %s\n", path)
+	}
+	if profile == "" {
+		fmt.Println("Profile: none")
+	} else {
+		fmt.Printf("Profile: %s\n", profile)
+	}
+	for _, c := range commands {
+		fmt.Printf("\n%s:\n", c.Name())
+		names := make([]string, 0, len(effective[c.Name()]))
+		width := 0
+		for name := range effective[c.Name()] {
+			names = append(names, name)
+			width = max(width, len(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			setting := effective[c.Name()][name]
+			fmt.Printf("  %-*s  %-7s  %s\n", width+1, name+":", setting.Source, setting.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// writeConfig writes a config file and makes it the one loaded for the rest of the test
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setGlobal(t, &configPath, path)
+	setGlobal(t, &profileName, "")
+}
+
+func TestConfigMapSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "map",
+			config: "map-field: {src: source_ip, dst: dest_ip}",
+			want:   "map[dst:dest_ip src:source_ip]",
+		},
+		{
+			name:   "block map with values holding '=' and ','",
+			config: "map-field:\n  query: search_query\n  url: 'a=b,c'",
+			want:   "map[query:search_query url:a=b,c]",
+		},
+		{
+			name:   "list of pairs",
+			config: "map-field: [src=source_ip, dst=dest_ip]",
+			want:   "map[dst:dest_ip src:source_ip]",
+		},
+		{
+			name:   "comma-separated pairs",
+			config: "map-field: src=source_ip,dst=dest_ip",
+			want:   "map[dst:dest_ip src:source_ip]",
+		},
+		{
+			name:    "nested value",
+			config:  "map-field: {src: [a, b]}",
+			wantErr: "config setting 'map-field' must map 'src' to a single value",
+		},
+		{
+			name:    "map for a flag that isn't key=value",
+			config:  "index: {a: b}",
+			wantErr: "config setting 'index' must be a value or a list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfig(t, "defaults:\n"+indent(tt.config))
+
+			// A stand-in for publish, so its flags are left alone
+			var mappings map[string]string
+			var index string
+			cmd := &cobra.Command{Use: "publish"}
+			cmd.Flags().StringToStringVar(&mappings, "map-field", nil, "")
+			cmd.Flags().StringVar(&index, "index", "", "")
+
+			err := applyConfig(cmd)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(mappings); got != tt.want {
+				t.Errorf("got --map-field %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// standInPublish returns a stand-in for publish with a few of its flags, so
+// the real command's flags are left alone
+func standInPublish() *cobra.Command {
+	cmd := &cobra.Command{Use: "publish"}
+	cmd.Flags().String("url", "", "")
+	cmd.Flags().String("index", "", "")
+	cmd.Flags().String("host", "", "")
+	cmd.Flags().String("source", "", "")
+	cmd.Flags().Int("batch-size", 100, "")
+	return cmd
+}
+
+func TestApplyConfig(t *testing.T) {
+	const profiles = `
+default_profile: lab
+defaults:
+  index: main
+  host: default-host
+  batch-size: 10
+profiles:
+  lab:
+    index: lab
+    publish:
+      index: lab-publish
+    replay:
+      index: lab-replay
+  prod:
+    index: prod
+    source: prod-source
+`
+	tests := []struct {
+		name    string
+		config  string
+		profile string            // --profile
+		env     map[string]string // Environment variables
+		flags   map[string]string // Given on the command line
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			config: "defaults:\n  index: main\n  batch-size: 10",
+			want:   map[string]string{"index": "main", "batch-size": "10", "host": ""},
+		},
+		{
+			name:   "environment beats the file",
+			config: "defaults:\n  index: main\n  batch-size: 10",
+			env:    map[string]string{"SPEXMA_BATCH_SIZE": "20"},
+			want:   map[string]string{"index": "main", "batch-size": "20"},
+		},
+		{
+			name:   "flags beat the environment and the file",
+			config: "defaults:\n  index: main\n  batch-size: 10",
+			env:    map[string]string{"SPEXMA_BATCH_SIZE": "20", "SPEXMA_INDEX": "env"},
+			flags:  map[string]string{"batch-size": "30"},
+			want:   map[string]string{"index": "env", "batch-size": "30"},
+		},
+		{
+			name:   "default profile over the defaults, command settings over the profile",
+			config: profiles,
+			want:   map[string]string{"index": "lab-publish", "host": "default-host", "batch-size": "10"},
+		},
+		{
+			name:    "--profile over the default profile",
+			config:  profiles,
+			profile: "prod",
+			want:    map[string]string{"index": "prod", "source": "prod-source", "host": "default-host"},
+		},
+		{
+			name:   "SPEXMA_PROFILE over the default profile",
+			config: profiles,
+			env:    map[string]string{"SPEXMA_PROFILE": "prod"},
+			want:   map[string]string{"index": "prod"},
+		},
+		{
+			name:    "unknown profile",
+			config:  profiles,
+			profile: "staging",
+			wantErr: "profile 'staging' not found in config file",
+		},
+		{
+			name:    "unknown setting",
+			config:  "defaults:\n  batch-sise: 10",
+			wantErr: "unknown config setting 'batch-sise'",
+		},
+		{
+			name:    "unknown command setting",
+			config:  "defaults:\n  publish:\n    batch-sise: 10",
+			wantErr: "unknown config setting 'batch-sise' for the publish command",
+		},
+		{
+			name:    "command settings that aren't a map",
+			config:  "defaults:\n  publish: 10",
+			wantErr: "config setting 'publish' must hold the settings of the publish command",
+		},
+		{
+			name:    "invalid value",
+			config:  "defaults:\n  batch-size: lots",
+			wantErr: "invalid value for batch-size",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfig(t, tt.config)
+			setGlobal(t, &profileName, tt.profile)
+			t.Setenv("SPEXMA_PROFILE", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cmd := standInPublish()
+			for name, value := range tt.flags {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := applyConfig(cmd)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := cmd.Flags().Lookup(name).Value.String(); got != want {
+					t.Errorf("got --%s %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequiredFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		env     string // SPEXMA_URL
+		wantErr string
+	}{
+		{
+			name:   "set by the file",
+			config: "defaults:\n  url: https://hec:8088",
+		},
+		{
+			name: "set by the environment",
+			env:  "https://hec:8088",
+		},
+		{
+			name:    "not set",
+			wantErr: `required flag(s) "url" not set`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfig(t, tt.config)
+			if tt.env != "" {
+				t.Setenv("SPEXMA_URL", tt.env)
+			}
+
+			cmd := standInPublish()
+			markRequired(cmd, "url")
+			err := applyConfig(cmd)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProfileWithoutConfigFile(t *testing.T) {
+	setGlobal(t, &configPath, "")
+	setGlobal(t, &profileName, "prod")
+	t.Setenv("SPEXMA_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	err := applyConfig(standInPublish())
+	if err == nil || !strings.Contains(err.Error(), "profile 'prod' given but there is no config file") {
+		t.Errorf("got error %v, want the profile to need a config file", err)
+	}
+}
+
+// indent indents YAML by two spaces to nest it under a key
+func indent(yaml string) string {
+	return "  " + strings.ReplaceAll(yaml, "\n", "\n  ") + "\n"
+}
@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/thezmc/spexma/internal/common/display"
+	"github.com/thezmc/spexma/internal/common/summary"
 	"github.com/thezmc/spexma/internal/publish/hec"
 )
 
@@ -30,15 +33,16 @@ var hecTestCmd = &cobra.Command{
 This command sends test events to a Splunk HEC endpoint to verify functionality.
 
 Example:
-  spexma hec-test -u https://splunk:8088/services/collector -t YOUR_HEC_TOKEN`,
+  spexma hec-test -u https://splunk:8088/services/collector --token-file hec-token.txt`,
 	Run: runHecTest,
 }
 
 func init() {
 	// Define flags for the command
 	hecTestCmd.Flags().StringVarP(&testHecURL, "url", "u", "", "Splunk HEC URL (required)")
-	hecTestCmd.Flags().StringVarP(&testHecToken, "token", "t", "", "Splunk HEC token (required)")
+	addTokenFlags(hecTestCmd, &testHecToken)
 	hecTestCmd.Flags().BoolVar(&testHecInsecure, "insecure", false, "Skip TLS verification")
+	addTLSFlags(hecTestCmd)
 	hecTestCmd.Flags().StringVar(&testIndex, "index", "", "Splunk index to send events to")
 	hecTestCmd.Flags().StringVar(&testHost, "host", "", "Host value for events")
 	hecTestCmd.Flags().StringVar(&testSource, "source", "spexma:test", "Source value for events")
@@ -47,23 +51,38 @@ func init() {
 	hecTestCmd.Flags().BoolVarP(&testVerbose, "verbose", "v", false, "Enable verbose output")
 
 	// Mark required flags
-	hecTestCmd.MarkFlagRequired("url")
-	hecTestCmd.MarkFlagRequired("token")
+	markRequired(hecTestCmd, "url")
 
 	// Add to root command
 	rootCmd.AddCommand(hecTestCmd)
 }
 
 func runHecTest(cmd *cobra.Command, args []string) {
-	fmt.Println("Splunk HEC Test")
-	fmt.Println("===============")
-	fmt.Printf("URL: %s\n", testHecURL)
-	fmt.Printf("Index: %s\n", testIndex)
-	fmt.Printf("Host: %s\n", testHost)
-	fmt.Printf("Source: %s\n", testSource)
-	fmt.Printf("Sourcetype: %s\n", testSourcetype)
-	fmt.Printf("Number of events: %d\n", testNumEvents)
-	fmt.Println()
+	runSummary := newSummary(cmd)
+	if err := resolveToken(&testHecToken); err != nil {
+		exitRun(runSummary, err)
+	}
+	testSummary := &summary.HECTest{}
+	runSummary.HECTest = testSummary
+
+	// recordStage adds a stage outcome to the run summary
+	recordStage := func(name string, err error) {
+		stage := summary.Stage{Name: name, Passed: err == nil}
+		if err != nil {
+			stage.Error = err.Error()
+		}
+		testSummary.Stages = append(testSummary.Stages, stage)
+	}
+
+	display.Println("Splunk HEC Test")
+	display.Println("===============")
+	display.Printf("URL: %s\n", testHecURL)
+	display.Printf("Index: %s\n", testIndex)
+	display.Printf("Host: %s\n", testHost)
+	display.Printf("Source: %s\n", testSource)
+	display.Printf("Sourcetype: %s\n", testSourcetype)
+	display.Printf("Number of events: %d\n", testNumEvents)
+	display.Println()
 
 	// Create HEC client
 	hecOptions := &hec.Options{
@@ -75,10 +94,16 @@ func runHecTest(cmd *cobra.Command, args []string) {
 		DefaultSource: testSource,
 		Debug:         testVerbose,
 	}
-	hecClient := hec.NewClient(testHecURL, testHecToken, hecOptions)
+	setTLSOptions(hecOptions)
+	hecClient, err := hec.NewClient(testHecURL, testHecToken, hecOptions)
+	if err != nil {
+		exitRun(runSummary, err)
+	}
+
+	ctx := context.Background()
 
 	// Test basic connectivity first with a single simple event
-	fmt.Println("Stage 1: Testing basic connectivity...")
+	display.Println("Stage 1: Testing basic connectivity...")
 	testEvent := hec.Event{
 		Event: map[string]interface{}{
 			"message": "HEC connection test from spexma",
@@ -87,15 +112,18 @@ func runHecTest(cmd *cobra.Command, args []string) {
 		SourceType: testSourcetype,
 	}
 
-	if err := hecClient.SendEvent(testEvent); err != nil {
-		fmt.Printf("ERROR: Basic connectivity test failed: %v\n", err)
+	if err := hecClient.SendEvent(ctx, testEvent); err != nil {
+		recordStage("basic_connectivity", err)
+		finishSummary(runSummary, err)
+		fmt.Fprintf(os.Stderr, "ERROR: Basic connectivity test failed: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Println("SUCCESS: Basic connectivity test passed")
-	fmt.Println()
+	recordStage("basic_connectivity", nil)
+	display.Println("SUCCESS: Basic connectivity test passed")
+	display.Println()
 
 	// Test with current timestamp
-	fmt.Println("Stage 2: Testing with current timestamp...")
+	display.Println("Stage 2: Testing with current timestamp...")
 	now := time.Now().Unix()
 	timestampEvent := hec.Event{
 		Time: &now,
@@ -106,16 +134,18 @@ func runHecTest(cmd *cobra.Command, args []string) {
 		SourceType: testSourcetype,
 	}
 
-	if err := hecClient.SendEvent(timestampEvent); err != nil {
-		fmt.Printf("ERROR: Timestamp test failed: %v\n", err)
-		fmt.Println("NOTE: This may indicate issues with timestamp handling")
+	err = hecClient.SendEvent(ctx, timestampEvent)
+	recordStage("timestamp", err)
+	if err != nil {
+		display.Printf("ERROR: Timestamp test failed: %v\n", err)
+		display.Println("NOTE: This may indicate issues with timestamp handling")
 	} else {
-		fmt.Println("SUCCESS: Timestamp test passed")
+		display.Println("SUCCESS: Timestamp test passed")
 	}
-	fmt.Println()
+	display.Println()
 
 	// Test with multiple events in a batch
-	fmt.Printf("Stage 3: Testing with a batch of %d events...\n", testNumEvents)
+	display.Printf("Stage 3: Testing with a batch of %d events...\n", testNumEvents)
 	var batchEvents []hec.Event
 	for i := 0; i < testNumEvents; i++ {
 		ts := time.Now().Add(time.Duration(i) * time.Second).Unix()
@@ -132,16 +162,18 @@ func runHecTest(cmd *cobra.Command, args []string) {
 		batchEvents = append(batchEvents, event)
 	}
 
-	if err := hecClient.SendEvents(batchEvents); err != nil {
-		fmt.Printf("ERROR: Batch test failed: %v\n", err)
-		fmt.Println("NOTE: This may indicate issues with batch processing")
+	err = hecClient.SendEvents(ctx, batchEvents)
+	recordStage("batch", err)
+	if err != nil {
+		display.Printf("ERROR: Batch test failed: %v\n", err)
+		display.Println("NOTE: This may indicate issues with batch processing")
 	} else {
-		fmt.Println("SUCCESS: Batch test passed")
+		display.Println("SUCCESS: Batch test passed")
 	}
-	fmt.Println()
+	display.Println()
 
 	// Test with a more complex event structure
-	fmt.Println("Stage 4: Testing with a complex event structure...")
+	display.Println("Stage 4: Testing with a complex event structure...")
 	complexEvent := hec.Event{
 		Event: map[string]interface{}{
 			"message":   "HEC complex event test from spexma",
@@ -159,20 +191,27 @@ func runHecTest(cmd *cobra.Command, args []string) {
 		SourceType: testSourcetype,
 	}
 
-	if err := hecClient.SendEvent(complexEvent); err != nil {
-		fmt.Printf("ERROR: Complex event test failed: %v\n", err)
-		fmt.Println("NOTE: This may indicate issues with complex data structures")
+	err = hecClient.SendEvent(ctx, complexEvent)
+	recordStage("complex_structure", err)
+	if err != nil {
+		display.Printf("ERROR: Complex event test failed: %v\n", err)
+		display.Println("NOTE: This may indicate issues with complex data structures")
 	} else {
-		fmt.Println("SUCCESS: Complex event test passed")
+		display.Println("SUCCESS: Complex event test passed")
+	}
+	display.Println()
+
+	finishSummary(runSummary, nil)
+	if !textSummary() {
+		return
 	}
-	fmt.Println()
 
 	// Final summary
-	fmt.Println("Test Summary")
-	fmt.Println("===========")
-	fmt.Println("All basic connectivity tests completed.")
-	fmt.Println("If you encountered any errors, review the output above for details.")
-	fmt.Println()
-	fmt.Println("To verify events were received, check your Splunk instance with the search:")
-	fmt.Printf("sourcetype=\"%s\"\n", testSourcetype)
+	display.Println("Test Summary")
+	display.Println("===========")
+	display.Println("All basic connectivity tests completed.")
+	display.Println("If you encountered any errors, review the output above for details.")
+	display.Println()
+	display.Println("To verify events were received, check your Splunk instance with the search:")
+	display.Printf("sourcetype=\"%s\"\n", testSourcetype)
 }
@@ -1,12 +1,22 @@
 package cmd
 
 import (
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/araddon/dateparse"
 	"github.com/spf13/cobra"
+	"github.com/thezmc/spexma/internal/common/display"
+	"github.com/thezmc/spexma/internal/common/summary"
 	"github.com/thezmc/spexma/internal/publish"
 	"github.com/thezmc/spexma/internal/publish/hec"
 )
@@ -20,11 +30,49 @@ var (
 	sourcetypeField string = "sourcetype"
 
 	// HEC options
-	hecURL       string
-	hecToken     string
-	hecInsecure  bool
-	hecBatchSize int           = 100
-	hecTimeout   time.Duration = 30 * time.Second
+	hecURLs             []string
+	hecToken            string
+	hecInsecure         bool
+	hecBatchSize        int           = 100
+	hecBatchBytes       int           = hec.DefaultMaxBatchBytes
+	hecTimeout          time.Duration = 30 * time.Second
+	hecChannel          string
+	hecEndpoint         string = hec.EndpointEvent
+	rawEventTime        bool
+	useGzip             bool
+	gzipLevel           int = 6
+	useAck              bool
+	balance             string        = hec.BalanceRoundRobin
+	unhealthyAfter      int           = hec.DefaultUnhealthyAfter
+	healthCheckInterval time.Duration = hec.DefaultHealthCheckInterval
+	ackTimeout          time.Duration = hec.DefaultAckTimeout
+	ackPoll             time.Duration = hec.DefaultAckPollInterval
+
+	// TLS and proxy options
+	caFile         string
+	clientCertFile string
+	clientKeyFile  string
+	tlsServerName  string
+	minTLSVersion  string
+	proxyURL       string
+
+	// Rate limit options
+	maxEPS             float64
+	maxBytesPerSec     string
+	rateLimitBy        string
+	maxEPSEach         float64
+	maxBytesPerSecEach string
+
+	// Field options
+	fieldMappings    map[string]string
+	mappingFile      string
+	constantFields   map[string]string
+	strictMapping    bool
+	preserveNulls    bool
+	discardInvalid   bool
+	defaultTimestamp string
+	indexedFields    []string
+	keepIndexed      bool
 
 	// Output options
 	indexName   string
@@ -35,6 +83,11 @@ var (
 	concurrency   int           = 4
 	retryCount    int           = 3
 	retryWait     time.Duration = 2 * time.Second
+	retryMaxWait  time.Duration = 30 * time.Second
+	retryElapsed  time.Duration = 5 * time.Minute
+	preserveOrder bool
+	resume        bool
+	stateFile     string
 	dryRun        bool
 	debugMode     bool
 	timeOffset    time.Duration = 0
@@ -49,6 +102,9 @@ var (
 	}
 )
 
+// Number of rejected events listed in the text summary
+const maxListedRejections = 20
+
 // publishCmd represents the publish command
 var publishCmd = &cobra.Command{
 	Use:   "publish",
@@ -57,7 +113,7 @@ var publishCmd = &cobra.Command{
 This command reads CSV files and sends the data as events to a Splunk instance.
 
 Example:
-  spexma publish -i ./split_data -u https://splunk:8088/services/collector -t YOUR_HEC_TOKEN`,
+  spexma publish -i ./split_data -u https://splunk:8088/services/collector --token-file hec-token.txt`,
 	Run: runPublish,
 }
 
@@ -70,12 +126,32 @@ func init() {
 	publishCmd.Flags().StringVar(&timeFormat, "time-format", timeFormat, "Format for parsing timestamps (epoch, epoch_ms, or a go time format string)")
 	publishCmd.Flags().StringVar(&sourcetypeField, "sourcetype-field", sourcetypeField, "Field containing the sourcetype")
 
+	// Field options
+	publishCmd.Flags().StringToStringVar(&fieldMappings, "map-field", nil, "Rename a field, as old=new; repeat or comma-separate for several")
+	publishCmd.Flags().StringVar(&mappingFile, "mapping-file", "", "File of field renames: a CSV of old,new pairs, or a YAML mapping if named .yaml or .yml")
+	publishCmd.Flags().StringToStringVar(&constantFields, "add-field", nil, "Add a field with a constant value to every event, as name=value; repeat or comma-separate for several")
+	publishCmd.Flags().StringArrayVar(&indexedFields, "indexed-fields", nil, "CSV columns or --add-field fields to send as HEC indexed fields, under their renamed names")
+	publishCmd.Flags().BoolVar(&keepIndexed, "keep-indexed-fields", false, "Also keep indexed fields in the event body")
+	publishCmd.Flags().BoolVar(&strictMapping, "strict-mapping", false, "Only send the fields renamed by --map-field or --mapping-file, and those from --add-field")
+	publishCmd.Flags().BoolVar(&preserveNulls, "preserve-nulls", false, "Send fields with empty values instead of leaving them out")
+	publishCmd.Flags().BoolVar(&discardInvalid, "discard-invalid", false, "Skip records whose timestamp can't be parsed, instead of sending them with --default-timestamp or none")
+	publishCmd.Flags().StringVar(&defaultTimestamp, "default-timestamp", "", "Timestamp for records without a valid one: \"now\" or a date and time (default: Splunk assigns the time it receives them)")
+
 	// HEC options
-	publishCmd.Flags().StringVarP(&hecURL, "url", "u", "", "Splunk HEC URL (required)")
-	publishCmd.Flags().StringVarP(&hecToken, "token", "t", "", "Splunk HEC token (required)")
+	publishCmd.Flags().StringSliceVarP(&hecURLs, "url", "u", nil, "Splunk HEC URL (required); repeat or comma-separate several to balance requests across them")
+	publishCmd.Flags().StringVar(&balance, "balance", balance, "How to spread requests across several URLs: round-robin or least-latency")
+	publishCmd.Flags().IntVar(&unhealthyAfter, "unhealthy-after", unhealthyAfter, "Take a URL out of rotation after this many consecutive failures, when there are several")
+	publishCmd.Flags().DurationVar(&healthCheckInterval, "health-check-interval", healthCheckInterval, "How often to health check a URL taken out of rotation")
+	addTokenFlags(publishCmd, &hecToken)
 	publishCmd.Flags().BoolVar(&hecInsecure, "insecure", false, "Skip TLS verification")
+	addTLSFlags(publishCmd)
 	publishCmd.Flags().IntVar(&hecBatchSize, "batch-size", hecBatchSize, "Number of events to send in a batch")
+	publishCmd.Flags().IntVar(&hecBatchBytes, "batch-bytes", hecBatchBytes, "Maximum payload bytes in a request; keep within HEC's max_content_length")
 	publishCmd.Flags().DurationVar(&hecTimeout, "timeout", hecTimeout, "HEC request timeout")
+	publishCmd.Flags().StringVar(&hecEndpoint, "endpoint", hecEndpoint, "HEC endpoint to send to: event (JSON events) or raw (the original _raw text, parsed by the sourcetype's props)")
+	publishCmd.Flags().BoolVar(&rawEventTime, "raw-event-time", false, "With --endpoint raw, send each event's time instead of letting Splunk extract it from the raw text; only consecutive events with the same time share a request, so this can send far more requests")
+	addAckFlags(publishCmd)
+	addGzipFlags(publishCmd)
 
 	// Output options
 	publishCmd.Flags().StringVar(&indexName, "index", "", "Splunk index to send events to")
@@ -85,23 +161,45 @@ func init() {
 	// Processing options
 	publishCmd.Flags().IntVar(&concurrency, "concurrency", concurrency, "Number of concurrent workers")
 	publishCmd.Flags().IntVar(&retryCount, "retry-count", retryCount, "Number of times to retry failed requests")
-	publishCmd.Flags().DurationVar(&retryWait, "retry-wait", retryWait, "Time to wait between retries")
+	publishCmd.Flags().DurationVar(&retryWait, "retry-wait", retryWait, "Time to wait before the first retry; later retries back off exponentially with jitter")
+	publishCmd.Flags().DurationVar(&retryMaxWait, "retry-max-wait", retryMaxWait, "Maximum time to wait between retries")
+	publishCmd.Flags().DurationVar(&retryElapsed, "retry-max-elapsed", retryElapsed, "Give up on a batch after retrying for this long (0 for no limit)")
+	publishCmd.Flags().BoolVar(&preserveOrder, "preserve-order", false, "Send each file's batches one at a time so its events arrive in order")
+	addRateLimitFlags(publishCmd)
+	publishCmd.Flags().BoolVar(&resume, "resume", false, "Skip files a previous run completed and continue partial ones from their checkpoint")
+	publishCmd.Flags().StringVar(&stateFile, "state-file", "", "File recording each file's progress for --resume (default a file under --run-directory named after the input directory)")
 	publishCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Don't actually send events, just show what would be sent")
 	publishCmd.Flags().BoolVar(&debugMode, "debug", false, "Enable debug logging")
 	publishCmd.Flags().DurationVar(&timeOffset, "time-offset", timeOffset, "Offset to apply to timestamps (e.g., -1h, +30m)")
 	publishCmd.Flags().StringArrayVar(&excludeFields, "exclude-fields", excludeFields, "Fields to exclude from the event; Splunk's default date expansion fields are excluded by default")
 
 	// Mark required flags
-	publishCmd.MarkFlagRequired("input-directory")
-	publishCmd.MarkFlagRequired("url")
-	publishCmd.MarkFlagRequired("token")
+	markRequired(publishCmd, "input-directory", "url")
 }
 
 func runPublish(cmd *cobra.Command, args []string) {
+	runSummary := newSummary(cmd)
+
+	if err := resolveToken(&hecToken); err != nil {
+		exitRun(runSummary, err)
+	}
+	if err := validateURLs(hecURLs); err != nil {
+		exitRun(runSummary, err)
+	}
+	if err := validateEndpoint(hecEndpoint); err != nil {
+		exitRun(runSummary, err)
+	}
+	if err := validateGzipLevel(gzipLevel); err != nil {
+		exitRun(runSummary, err)
+	}
+
+	if balance != hec.BalanceRoundRobin && balance != hec.BalanceLeastLatency {
+		exitRun(runSummary, fmt.Errorf("invalid balance '%s' (expected %s or %s)", balance, hec.BalanceRoundRobin, hec.BalanceLeastLatency))
+	}
+
 	// Validate input directory
 	if _, err := os.Stat(inputDirectory); os.IsNotExist(err) {
-		fmt.Printf("Error: Input directory '%s' does not exist\n", inputDirectory)
-		os.Exit(1)
+		exitRun(runSummary, fmt.Errorf("input directory '%s' does not exist", inputDirectory))
 	}
 
 	// Create HEC client
@@ -109,52 +207,70 @@ func runPublish(cmd *cobra.Command, args []string) {
 		InsecureSSL:   hecInsecure,
 		Timeout:       hecTimeout,
 		BatchSize:     hecBatchSize,
+		MaxBatchBytes: hecBatchBytes,
+		Concurrency:   concurrency,
 		DefaultIndex:  indexName,
 		DefaultHost:   hostValue,
 		DefaultSource: sourceValue,
 		Debug:         debugMode,
 	}
-	hecClient := hec.NewClient(hecURL, hecToken, hecOptions)
+	setAckOptions(hecOptions)
+	setGzipOptions(hecOptions)
+	setTLSOptions(hecOptions)
+	hecOptions.Endpoint = hecEndpoint
+	hecOptions.RawEventTime = rawEventTime
+	hecOptions.URLs = hecURLs[1:]
+	hecOptions.Balance = balance
+	hecOptions.UnhealthyAfter = unhealthyAfter
+	hecOptions.HealthCheckInterval = healthCheckInterval
+	hecOptions.OnHealthChange = reportHealthChange
+	hecClient, err := hec.NewClient(hecURLs[0], hecToken, hecOptions)
+	if err != nil {
+		exitRun(runSummary, err)
+	}
+
+	rateLimiter, err := newRateLimiter()
+	if err != nil {
+		exitRun(runSummary, err)
+	}
 
 	// Print debug configuration if enabled
 	if debugMode {
-		fmt.Println("Debug mode enabled - detailed logs will be printed")
-		fmt.Printf("HEC URL: %s\n", hecURL)
-		fmt.Printf("Index: %s\n", indexName)
-		fmt.Printf("Host: %s\n", hostValue)
-		fmt.Printf("Source: %s\n", sourceValue)
-		fmt.Printf("Batch size: %d\n", hecBatchSize)
-		fmt.Printf("Time field: %s\n", timeField)
-		fmt.Printf("Time format: %s\n", timeFormat)
+		display.Println("Debug mode enabled - detailed logs will be printed")
+		display.Printf("HEC URLs: %s (%s)\n", strings.Join(hecURLs, ", "), balance)
+		display.Printf("Index: %s\n", indexName)
+		display.Printf("Host: %s\n", hostValue)
+		display.Printf("Source: %s\n", sourceValue)
+		display.Printf("Batch size: %d events, %s\n", hecBatchSize, display.FormatBytes(int64(hecBatchBytes)))
+		display.Printf("Endpoint: %s\n", hecEndpoint)
+		if hecClient.UseACK {
+			display.Printf("Indexer acknowledgement: enabled (channel %s)\n", hecClient.Channel)
+		}
+		display.Printf("Time field: %s\n", timeField)
+		display.Printf("Time format: %s\n", timeFormat)
 	}
 
 	// Verify HEC connection (unless in dry run mode)
 	if !dryRun {
-		fmt.Println("Testing connection to Splunk HEC...")
+		display.Println("Testing connection to Splunk HEC...")
 		if err := hecClient.HealthCheck(); err != nil {
-			fmt.Printf("Error connecting to Splunk HEC: %v\n", err)
-			fmt.Println("If you're testing, you can use --dry-run to skip the connection check.")
+			fmt.Fprintf(os.Stderr, "Error connecting to Splunk HEC: %v\n", err)
+			fmt.Fprintln(os.Stderr, "If you're testing, you can use --dry-run to skip the connection check.")
+			finishSummary(runSummary, err)
 			os.Exit(1)
 		}
-		fmt.Println("Connection successful!")
+		display.Println("Connection successful!")
 	}
 
 	// Create transformer configuration
-	tConfig := &publish.TransformerConfig{
-		ExcludeFields:    []string{},
-		TimeField:        timeField,
-		TimeFormat:       timeFormat,
-		Host:             hostValue,
-		Source:           sourceValue,
-		Index:            indexName,
-		PreserveNulls:    false,
-		StrictMapping:    false,
-		DefaultTimestamp: nil, // Use current time as default if needed
-		TimeOffset:       timeOffset,
-	}
-
-	// If the current hostname should be used
-	if hostValue == "auto" || hostValue == "" {
+	tConfig, err := newTransformerConfig()
+	if err != nil {
+		exitRun(runSummary, err)
+	}
+
+	// If the current hostname should be used. Raw events keep their
+	// original host unless told otherwise.
+	if hostValue == "auto" || (hostValue == "" && !tConfig.RawMode) {
 		hostname, err := os.Hostname()
 		if err == nil {
 			tConfig.Host = hostname
@@ -167,18 +283,38 @@ func runPublish(cmd *cobra.Command, args []string) {
 	// Create progress channel
 	progressCh := make(chan *publish.Progress, 10)
 
+	// Record each file's progress so an interrupted run can be resumed
+	var checkpoints *publish.Checkpointer
+	if !dryRun {
+		var err error
+		checkpoints, err = newCheckpointer()
+		if err != nil {
+			exitRun(runSummary, err)
+		}
+	}
+
+	// Keep events that fail to publish so they can be replayed
+	deadLetters := publish.NewDeadLetterWriter(filepath.Join(newRunDirectory(runSummary), publish.DeadLetterFileName))
+
 	// Create publisher configuration
 	pConfig := &publish.PublisherConfig{
 		HECClient:       hecClient,
 		Transformer:     t,
 		Concurrency:     concurrency,
 		BatchSize:       hecBatchSize,
+		BatchBytes:      hecBatchBytes,
 		RetryCount:      retryCount,
 		RetryWait:       retryWait,
+		RetryMaxWait:    retryMaxWait,
+		RetryMaxElapsed: retryElapsed,
 		OutputDirectory: "", // Not used for publish
 		ProgressCh:      progressCh,
 		DryRun:          dryRun,
 		Debug:           debugMode,
+		PreserveOrder:   preserveOrder,
+		DeadLetter:      deadLetters,
+		Checkpoints:     checkpoints,
+		RateLimiter:     rateLimiter,
 	}
 
 	// Create publisher
@@ -190,16 +326,28 @@ func runPublish(cmd *cobra.Command, args []string) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		publish.DisplayProgress(p.GetProgress(), displayDone)
+		publish.DisplayProgress(cmd.Name(), p.GetProgress(), displayDone)
 	}()
 
 	// Start publishing
-	fmt.Printf("Publishing CSV data from '%s' to Splunk HEC at '%s'\n", inputDirectory, hecURL)
+	display.Printf("Publishing CSV data from '%s' to Splunk HEC at '%s'\n", inputDirectory, strings.Join(hecURLs, "', '"))
 	if dryRun {
-		fmt.Println("DRY RUN MODE: No events will actually be sent to Splunk")
+		display.Println("DRY RUN MODE: No events will actually be sent to Splunk")
 	}
 
-	err := p.PublishDirectory(inputDirectory)
+	// Stop cleanly on interrupt so the summary still reflects what was sent
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = p.PublishDirectory(ctx, inputDirectory)
+	err = closeDeadLetters(deadLetters, err)
+	if checkpoints != nil {
+		// Failing to record progress doesn't undo the sends, so it doesn't
+		// fail the run; it only means --resume may send some events again
+		if flushErr := checkpoints.Flush(); flushErr != nil {
+			display.Warnf("%v\n", flushErr)
+		}
+	}
 
 	// Signal display to stop
 	close(displayDone)
@@ -207,27 +355,395 @@ func runPublish(cmd *cobra.Command, args []string) {
 	// Wait for display to finish
 	wg.Wait()
 
+	// Record the run summary
+	publishSummary := buildPublishSummary(p, hecClient, deadLetters)
+	publishSummary.InputDirectory = inputDirectory
+	publishSummary.DryRun = dryRun
+	if checkpoints != nil {
+		publishSummary.StateFile = checkpoints.Path()
+	}
+	runSummary.Publish = publishSummary
+	finishSummary(runSummary, err)
+
 	// Check for errors
 	if err != nil {
-		fmt.Printf("\nError during publishing: %v\n", err)
+		fmt.Fprintf(os.Stderr, "\nError during publishing: %v\n", err)
+		if publishSummary.DeadLetterFile != "" {
+			fmt.Fprintf(os.Stderr, "Failed events written to %s\n", publishSummary.DeadLetterFile)
+		}
+		if publishSummary.StateFile != "" {
+			fmt.Fprintf(os.Stderr, "Progress saved to %s; rerun with --resume to continue\n", publishSummary.StateFile)
+		}
 		os.Exit(1)
 	}
 
-	// Get final stats
-	processedFiles, totalFiles, publishedEvents, totalEvents, _, elapsed, _ := p.GetProgress().GetStats()
+	if !textSummary() {
+		return
+	}
 
 	// Print summary
-	fmt.Println("\nPublishing completed!")
-	fmt.Println("Summary:")
-	fmt.Println("--------------------")
-	fmt.Printf("Files processed: %d/%d\n", processedFiles, totalFiles)
-	fmt.Printf("Events published: %d/%d\n", publishedEvents, totalEvents)
-	fmt.Printf("Total time: %s\n", elapsed.Round(time.Second))
-	fmt.Printf("Average rate: %.2f events/second\n", float64(publishedEvents)/elapsed.Seconds())
+	display.Println("\nPublishing completed!")
+	printPublishSummary(p, publishSummary)
 
 	// Show additional info for dry run
 	if dryRun {
-		fmt.Println("\nThis was a dry run. No events were actually sent to Splunk.")
-		fmt.Println("Remove the --dry-run flag to publish events for real.")
+		display.Println("\nThis was a dry run. No events were actually sent to Splunk.")
+		display.Println("Remove the --dry-run flag to publish events for real.")
+	}
+}
+
+// validateEndpoint checks the --endpoint flag
+func validateEndpoint(endpoint string) error {
+	if endpoint != hec.EndpointEvent && endpoint != hec.EndpointRaw {
+		return fmt.Errorf("invalid endpoint '%s' (expected %s or %s)", endpoint, hec.EndpointEvent, hec.EndpointRaw)
+	}
+	return nil
+}
+
+// addGzipFlags adds the request compression flags to a command that sends events
+func addGzipFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&useGzip, "gzip", false, "Compress requests to HEC with gzip")
+	cmd.Flags().IntVar(&gzipLevel, "gzip-level", gzipLevel, "Gzip compression level, from 1 (fastest) to 9 (smallest)")
+}
+
+// validateGzipLevel checks the --gzip-level flag
+func validateGzipLevel(level int) error {
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return fmt.Errorf("invalid gzip level %d (expected %d to %d)", level, gzip.BestSpeed, gzip.BestCompression)
+	}
+	return nil
+}
+
+// setGzipOptions applies the request compression flags to HEC client options
+func setGzipOptions(options *hec.Options) {
+	options.Gzip = useGzip
+	options.GzipLevel = gzipLevel
+}
+
+// newTransformerConfig builds the transformer configuration from the flags
+func newTransformerConfig() (*publish.TransformerConfig, error) {
+	config := &publish.TransformerConfig{
+		ExcludeFields:  excludeFields,
+		FieldMappings:  map[string]string{},
+		ConstantFields: constantFields,
+		TimeField:      timeField,
+		TimeFormat:     timeFormat,
+		Host:           hostValue,
+		Source:         sourceValue,
+		Index:          indexName,
+		PreserveNulls:  preserveNulls,
+		StrictMapping:  strictMapping,
+		DiscardInvalid: discardInvalid,
+		TimeOffset:     timeOffset,
+		RawMode:        hecEndpoint == hec.EndpointRaw,
+		IndexedFields:  indexedFields,
+		KeepIndexed:    keepIndexed,
+	}
+
+	// Renames given as flags take precedence over the mapping file
+	if mappingFile != "" {
+		mappings, err := publish.LoadFieldMappings(mappingFile)
+		if err != nil {
+			return nil, err
+		}
+		config.FieldMappings = mappings
+	}
+	for old, name := range fieldMappings {
+		config.FieldMappings[old] = name
+	}
+
+	if config.StrictMapping && len(config.FieldMappings) == 0 {
+		return nil, errors.New("--strict-mapping needs field renames from --map-field or --mapping-file")
+	}
+	if config.RawMode && (len(config.FieldMappings) > 0 || len(config.ConstantFields) > 0 || config.StrictMapping || config.PreserveNulls) {
+		return nil, errors.New("the raw endpoint sends events' original text, so fields can't be renamed, added or kept when empty")
+	}
+	if config.RawMode && len(config.IndexedFields) > 0 {
+		return nil, errors.New("the raw endpoint doesn't take indexed fields")
+	}
+	if err := config.ValidateIndexedFields(); err != nil {
+		return nil, err
+	}
+
+	switch defaultTimestamp {
+	case "":
+	case "now":
+		now := time.Now()
+		config.DefaultTimestamp = &now
+	default:
+		timestamp, err := dateparse.ParseAny(defaultTimestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default timestamp '%s': %w", defaultTimestamp, err)
+		}
+		config.DefaultTimestamp = &timestamp
+	}
+
+	return config, nil
+}
+
+// addTLSFlags adds the TLS and proxy flags to a command that connects to HEC
+func addTLSFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&caFile, "ca-file", "", "PEM CA bundle to trust, along with the system's CAs, when verifying HEC's certificate")
+	cmd.Flags().StringVar(&clientCertFile, "client-cert", "", "PEM client certificate for HEC endpoints requiring mutual TLS")
+	cmd.Flags().StringVar(&clientKeyFile, "client-key", "", "PEM private key of --client-cert")
+	cmd.Flags().StringVar(&tlsServerName, "tls-server-name", "", "Name to verify HEC's certificate against, instead of the URL's host")
+	cmd.Flags().StringVar(&minTLSVersion, "tls-min-version", "", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3 (default 1.2)")
+	cmd.Flags().StringVar(&proxyURL, "proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL for HEC requests (default from HTTPS_PROXY, HTTP_PROXY and NO_PROXY)")
+}
+
+// setTLSOptions applies the TLS and proxy flags to HEC client options
+func setTLSOptions(options *hec.Options) {
+	options.CAFile = caFile
+	options.ClientCertFile = clientCertFile
+	options.ClientKeyFile = clientKeyFile
+	options.ServerName = tlsServerName
+	options.MinTLSVersion = minTLSVersion
+	options.ProxyURL = proxyURL
+}
+
+// validateURLs checks the --url flag gave at least one URL, and no empty ones
+func validateURLs(urls []string) error {
+	if len(urls) == 0 {
+		return errors.New("at least one HEC URL is required")
+	}
+	for _, u := range urls {
+		if strings.TrimSpace(u) == "" {
+			return errors.New("HEC URLs can't be empty")
+		}
+	}
+	return nil
+}
+
+// reportHealthChange warns when a HEC URL leaves or rejoins the rotation
+func reportHealthChange(url string, healthy bool, err error) {
+	if healthy {
+		display.Warnf("%s passed a health check and is back in rotation\n", url)
+		return
+	}
+	display.Warnf("%s taken out of rotation: %v\n", url, err)
+}
+
+// addRateLimitFlags adds the ingest rate limit flags to a command that sends events
+func addRateLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().Float64Var(&maxEPS, "max-eps", 0, "Maximum events per second across all workers (0 for no limit)")
+	cmd.Flags().StringVar(&maxBytesPerSec, "max-bytes-per-sec", "", "Maximum payload bytes per second across all workers, e.g. 5MB (before compression)")
+	cmd.Flags().StringVar(&rateLimitBy, "rate-limit-by", "", "Also limit each distinct sourcetype or index with --max-eps-each and --max-bytes-per-sec-each")
+	cmd.Flags().Float64Var(&maxEPSEach, "max-eps-each", 0, "Maximum events per second for each sourcetype or index (requires --rate-limit-by)")
+	cmd.Flags().StringVar(&maxBytesPerSecEach, "max-bytes-per-sec-each", "", "Maximum payload bytes per second for each sourcetype or index (requires --rate-limit-by)")
+}
+
+// newRateLimiter creates the rate limiter set by the rate limit flags, or nil if there are no limits
+func newRateLimiter() (*publish.RateLimiter, error) {
+	limits := publish.RateLimits{
+		EventsPerSecond:     maxEPS,
+		By:                  rateLimitBy,
+		EventsPerSecondEach: maxEPSEach,
+	}
+	if maxEPS < 0 || maxEPSEach < 0 {
+		return nil, fmt.Errorf("events per second limits can't be negative")
+	}
+	if maxBytesPerSec != "" {
+		n, err := display.ParseBytes(maxBytesPerSec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --max-bytes-per-sec: %w", err)
+		}
+		limits.BytesPerSecond = float64(n)
+	}
+	if maxBytesPerSecEach != "" {
+		n, err := display.ParseBytes(maxBytesPerSecEach)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --max-bytes-per-sec-each: %w", err)
+		}
+		limits.BytesPerSecondEach = float64(n)
+	}
+	if rateLimitBy == "" && (limits.EventsPerSecondEach > 0 || limits.BytesPerSecondEach > 0) {
+		return nil, fmt.Errorf("--max-eps-each and --max-bytes-per-sec-each require --rate-limit-by")
+	}
+	return publish.NewRateLimiter(limits)
+}
+
+// addAckFlags adds the indexer acknowledgement flags to a command that sends events
+func addAckFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&useAck, "ack", false, "Wait for indexer acknowledgement of every batch (the token must have indexer acknowledgement enabled)")
+	cmd.Flags().DurationVar(&ackTimeout, "ack-timeout", ackTimeout, "Resend a batch that isn't acknowledged within this long")
+	cmd.Flags().DurationVar(&ackPoll, "ack-poll-interval", ackPoll, "How often to poll for acknowledgement")
+	cmd.Flags().StringVar(&hecChannel, "channel", "", "HEC request channel GUID (generated when --ack is set)")
+}
+
+// setAckOptions applies the indexer acknowledgement flags to HEC client options
+func setAckOptions(options *hec.Options) {
+	options.Channel = hecChannel
+	options.UseACK = useAck
+	options.AckTimeout = ackTimeout
+	options.AckPollInterval = ackPoll
+}
+
+// newCheckpointer opens the publish state file, continuing from it with --resume
+func newCheckpointer() (*publish.Checkpointer, error) {
+	path := stateFile
+	if path == "" {
+		var err error
+		if path, err = publish.StatePath(runDirectory, inputDirectory); err != nil {
+			return nil, err
+		}
+	}
+
+	var previous *publish.PublishState
+	if resume {
+		var err error
+		previous, err = publish.LoadPublishState(path)
+		if err != nil {
+			return nil, err
+		}
+		// Fall back to where earlier versions kept the state
+		if previous == nil && stateFile == "" {
+			legacy := filepath.Join(inputDirectory, publish.StateFileName)
+			if previous, err = publish.LoadPublishState(legacy); err != nil {
+				return nil, err
+			}
+		}
+		if previous == nil {
+			display.Warnf("no state file at %s, publishing every file from the start\n", path)
+		}
+	}
+
+	return publish.NewCheckpointer(path, inputDirectory, previous)
+}
+
+// closeDeadLetters closes the dead-letter file, folding a failure to write it into the run's error
+func closeDeadLetters(deadLetters *publish.DeadLetterWriter, runErr error) error {
+	if err := deadLetters.Close(); err != nil {
+		if runErr == nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return runErr
+}
+
+// buildPublishSummary records the totals and per-file results of a publisher's run
+func buildPublishSummary(p *publish.Publisher, hecClient *hec.Client, deadLetters *publish.DeadLetterWriter) *summary.Publish {
+	processedFiles, totalFiles, publishedEvents, totalEvents, _, elapsed, _ := p.GetProgress().GetStats()
+	publishSummary := &summary.Publish{
+		TotalFiles:      totalFiles,
+		ProcessedFiles:  processedFiles,
+		TotalEvents:     totalEvents,
+		PublishedEvents: publishedEvents,
+	}
+	if elapsed > 0 {
+		publishSummary.EventsPerSecond = float64(publishedEvents) / elapsed.Seconds()
+	}
+	for _, stats := range hecClient.EndpointStats() {
+		publishSummary.Endpoints = append(publishSummary.Endpoints, summary.Endpoint{
+			URL:              stats.URL,
+			Healthy:          stats.Healthy,
+			Requests:         stats.Requests,
+			FailedRequests:   stats.FailedRequests,
+			Events:           stats.Events,
+			Bytes:            stats.Bytes,
+			AverageLatencyMS: float64(stats.AverageLatency()) / float64(time.Millisecond),
+			Removals:         stats.Removals,
+		})
+	}
+	rawBytes, wireBytes := hecClient.ByteCounts()
+	publishSummary.RawBytes = rawBytes
+	if hecClient.Gzip {
+		publishSummary.CompressedBytes = wireBytes
+	}
+	for _, result := range p.GetFileResults() {
+		file := summary.File{
+			File:            result.File,
+			Sourcetype:      result.SourceType,
+			Status:          "success",
+			TotalEvents:     result.TotalEvents,
+			PublishedEvents: result.PublishedEvents,
+			FailedEvents:    result.FailedEvents,
+			DurationSeconds: result.Duration.Seconds(),
+			ResumedAtRow:    result.ResumedAt,
+		}
+		if result.Err != nil {
+			file.Status = "failed"
+			file.Error = result.Err.Error()
+		} else if result.Skipped {
+			file.Status = "skipped"
+		} else if result.FailedEvents > 0 {
+			file.Status = "partial"
+		}
+		publishSummary.FailedEvents += result.FailedEvents
+		publishSummary.Files = append(publishSummary.Files, file)
+	}
+	for _, rejected := range p.GetRejectedEvents() {
+		publishSummary.RejectedEvents = append(publishSummary.RejectedEvents, summary.RejectedEvent{
+			File:   rejected.File,
+			Line:   rejected.Line,
+			Reason: rejected.Err.Error(),
+		})
+	}
+	if deadLetters.Count() > 0 {
+		publishSummary.DeadLetterFile = deadLetters.Path()
+	}
+	return publishSummary
+}
+
+// printPublishSummary prints the human-readable summary of a publisher's run
+func printPublishSummary(p *publish.Publisher, publishSummary *summary.Publish) {
+	_, _, _, _, _, elapsed, _ := p.GetProgress().GetStats()
+	rejectedEvents := p.GetRejectedEvents()
+
+	display.Println("Summary:")
+	display.Println("--------------------")
+	display.Printf("Files processed: %d/%d\n", publishSummary.ProcessedFiles, publishSummary.TotalFiles)
+	skippedFiles := 0
+	for _, file := range publishSummary.Files {
+		if file.Status == "skipped" {
+			skippedFiles++
+		}
+	}
+	if skippedFiles > 0 {
+		display.Printf("Files skipped (already published): %d\n", skippedFiles)
+	}
+	display.Printf("Events published: %d/%d\n", publishSummary.PublishedEvents, publishSummary.TotalEvents)
+	display.Printf("Total time: %s\n", elapsed.Round(time.Second))
+	display.Printf("Average rate: %.2f events/second\n", publishSummary.EventsPerSecond)
+	if publishSummary.CompressedBytes > 0 {
+		display.Printf("Data sent: %s (%s compressed, %.1f%%)\n",
+			display.FormatBytes(publishSummary.RawBytes), display.FormatBytes(publishSummary.CompressedBytes),
+			float64(publishSummary.CompressedBytes)/float64(publishSummary.RawBytes)*100)
+	} else if publishSummary.RawBytes > 0 {
+		display.Printf("Data sent: %s\n", display.FormatBytes(publishSummary.RawBytes))
+	}
+
+	// Show how requests were spread across several URLs
+	if len(publishSummary.Endpoints) > 1 {
+		display.Println("\nEndpoints:")
+		for _, endpoint := range publishSummary.Endpoints {
+			health := "healthy"
+			if !endpoint.Healthy {
+				health = "unhealthy"
+			}
+			display.Printf("  %s: %d events, %d requests (%d failed), %.1f ms average, %s",
+				endpoint.URL, endpoint.Events, endpoint.Requests, endpoint.FailedRequests, endpoint.AverageLatencyMS, health)
+			if endpoint.Removals > 0 {
+				display.Printf(", times taken out of rotation: %d", endpoint.Removals)
+			}
+			display.Println()
+		}
+	}
+
+	// List the events HEC refused, so they can be found in the source files
+	if len(rejectedEvents) > 0 {
+		display.Printf("\nRejected events: %d\n", len(rejectedEvents))
+		for i, rejected := range rejectedEvents {
+			if i == maxListedRejections {
+				display.Printf("... and %d more (see --summary-file for the full list)\n", len(rejectedEvents)-i)
+				break
+			}
+			display.Printf("  %s:%d: %v\n", rejected.File, rejected.Line, rejected.Err)
+		}
+	}
+
+	if publishSummary.DeadLetterFile != "" {
+		display.Printf("\nFailed events written to %s\n", publishSummary.DeadLetterFile)
+		display.Printf("Resend them with: spexma replay -f %s --token-file <file>\n", publishSummary.DeadLetterFile)
 	}
 }
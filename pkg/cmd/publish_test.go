@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thezmc/spexma/internal/publish/hec"
+)
+
+func TestNewTransformerConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		mappingFile  string // Contents of --mapping-file, a CSV, if set
+		mapField     map[string]string
+		addField     map[string]string
+		exclude      []string
+		strict       bool
+		endpoint     string
+		timestamp    string // --default-timestamp
+		wantMappings string
+		wantExclude  string
+		wantErr      string
+	}{
+		{
+			name:         "mapping file",
+			mappingFile:  "src,src_ip\ndst,dest_ip\n",
+			wantMappings: "map[dst:dest_ip src:src_ip]",
+		},
+		{
+			name:         "--map-field over the mapping file",
+			mappingFile:  "src,src_ip\ndst,dest_ip\n",
+			mapField:     map[string]string{"src": "source_ip", "user": "src_user"},
+			wantMappings: "map[dst:dest_ip src:source_ip user:src_user]",
+		},
+		{
+			name:        "--exclude-fields is passed on",
+			exclude:     []string{"date_hour", "secret"},
+			wantExclude: "[date_hour secret]",
+		},
+		{
+			name:    "strict with nothing renamed",
+			strict:  true,
+			wantErr: "--strict-mapping needs field renames from --map-field or --mapping-file",
+		},
+		{
+			name:     "renames on the raw endpoint",
+			mapField: map[string]string{"src": "src_ip"},
+			endpoint: hec.EndpointRaw,
+			wantErr:  "fields can't be renamed, added or kept when empty",
+		},
+		{
+			name:     "added fields on the raw endpoint",
+			addField: map[string]string{"env": "lab"},
+			endpoint: hec.EndpointRaw,
+			wantErr:  "fields can't be renamed, added or kept when empty",
+		},
+		{
+			name:      "invalid default timestamp",
+			timestamp: "someday",
+			wantErr:   "invalid default timestamp 'someday'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &mappingFile, "")
+			if tt.mappingFile != "" {
+				path := filepath.Join(t.TempDir(), "mappings.csv")
+				if err := os.WriteFile(path, []byte(tt.mappingFile), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				mappingFile = path
+			}
+			setGlobal(t, &fieldMappings, tt.mapField)
+			setGlobal(t, &constantFields, tt.addField)
+			setGlobal(t, &excludeFields, tt.exclude)
+			setGlobal(t, &strictMapping, tt.strict)
+			setGlobal(t, &defaultTimestamp, tt.timestamp)
+			setGlobal(t, &hecEndpoint, hec.EndpointEvent)
+			if tt.endpoint != "" {
+				hecEndpoint = tt.endpoint
+			}
+
+			config, err := newTransformerConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantMappings != "" && fmt.Sprint(config.FieldMappings) != tt.wantMappings {
+				t.Errorf("got mappings %v, want %s", config.FieldMappings, tt.wantMappings)
+			}
+			if tt.wantExclude != "" && fmt.Sprint(config.ExcludeFields) != tt.wantExclude {
+				t.Errorf("got excluded fields %v, want %s", config.ExcludeFields, tt.wantExclude)
+			}
+		})
+	}
+}
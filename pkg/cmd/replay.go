@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/thezmc/spexma/internal/common/display"
+	"github.com/thezmc/spexma/internal/publish"
+	"github.com/thezmc/spexma/internal/publish/hec"
+)
+
+// Options for the replay command; HEC and processing options are shared with publish
+var (
+	deadLetterFile string
+	replayURL      string
+	replayEndpoint string
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Resend events from a dead-letter file to Splunk HEC",
+	Long: `Resend the events in a dead-letter file written by publish (or by an earlier replay).
+Events are sent to the URL they originally failed against unless --url is given
+(with several publish URLs, the first one recorded in the file), and keep their original index unless --index is given. Events that fail again are
+written to a new dead-letter file under the run directory.
+
+Example:
+  spexma replay -f spexma-runs/publish-20250101T120000.000Z/dead-letter.ndjson --token-file hec-token.txt`,
+	Run: runReplay,
+}
+
+func init() {
+	// Input options
+	replayCmd.Flags().StringVarP(&deadLetterFile, "file", "f", "", "Dead-letter file to replay (required)")
+
+	// HEC options
+	replayCmd.Flags().StringVarP(&replayURL, "url", "u", "", "Splunk HEC URL (defaults to the URL the events failed against)")
+	addTokenFlags(replayCmd, &hecToken)
+	replayCmd.Flags().BoolVar(&hecInsecure, "insecure", false, "Skip TLS verification")
+	addTLSFlags(replayCmd)
+	replayCmd.Flags().IntVar(&hecBatchSize, "batch-size", hecBatchSize, "Number of events to send in a batch")
+	replayCmd.Flags().IntVar(&hecBatchBytes, "batch-bytes", hecBatchBytes, "Maximum payload bytes in a request; keep within HEC's max_content_length")
+	replayCmd.Flags().DurationVar(&hecTimeout, "timeout", hecTimeout, "HEC request timeout")
+	replayCmd.Flags().StringVar(&replayEndpoint, "endpoint", "", "HEC endpoint to send to, event or raw (defaults to the endpoint the events failed against)")
+	replayCmd.Flags().BoolVar(&rawEventTime, "raw-event-time", false, "With the raw endpoint, send each event's time instead of letting Splunk extract it from the raw text; only consecutive events with the same time share a request, so this can send far more requests")
+	addAckFlags(replayCmd)
+	addGzipFlags(replayCmd)
+
+	// Output options
+	replayCmd.Flags().StringVar(&indexName, "index", "", "Splunk index to send events to, instead of their original one")
+
+	// Processing options
+	replayCmd.Flags().IntVar(&concurrency, "concurrency", concurrency, "Number of concurrent workers")
+	replayCmd.Flags().IntVar(&retryCount, "retry-count", retryCount, "Number of times to retry failed requests")
+	replayCmd.Flags().DurationVar(&retryWait, "retry-wait", retryWait, "Time to wait before the first retry; later retries back off exponentially with jitter")
+	replayCmd.Flags().DurationVar(&retryMaxWait, "retry-max-wait", retryMaxWait, "Maximum time to wait between retries")
+	replayCmd.Flags().DurationVar(&retryElapsed, "retry-max-elapsed", retryElapsed, "Give up on a batch after retrying for this long (0 for no limit)")
+	replayCmd.Flags().BoolVar(&preserveOrder, "preserve-order", false, "Send each source file's batches one at a time so its events arrive in order")
+	addRateLimitFlags(replayCmd)
+	replayCmd.Flags().BoolVar(&debugMode, "debug", false, "Enable debug logging")
+
+	// Mark required flags
+	markRequired(replayCmd, "file")
+}
+
+func runReplay(cmd *cobra.Command, args []string) {
+	runSummary := newSummary(cmd)
+
+	if err := resolveToken(&hecToken); err != nil {
+		exitRun(runSummary, err)
+	}
+
+	// Send to the URL and endpoint the events failed against unless told otherwise
+	failedURL, failedEndpoint, err := deadLetterTarget(deadLetterFile)
+	if err != nil {
+		exitRun(runSummary, err)
+	}
+	url := replayURL
+	if url == "" {
+		if failedURL == "" {
+			exitRun(runSummary, fmt.Errorf("dead-letter file %s doesn't record a URL; use --url", deadLetterFile))
+		}
+		url = failedURL
+	}
+	endpoint := replayEndpoint
+	if endpoint == "" {
+		endpoint = failedEndpoint
+	}
+	if endpoint == "" {
+		endpoint = hec.EndpointEvent
+	}
+	if err := validateEndpoint(endpoint); err != nil {
+		exitRun(runSummary, err)
+	}
+	if err := validateGzipLevel(gzipLevel); err != nil {
+		exitRun(runSummary, err)
+	}
+
+	// Create HEC client. The events carry their own index, host and source,
+	// so no defaults are applied. An --index replaces every event's index,
+	// so it's the client's default for classifying an incorrect index.
+	hecOptions := &hec.Options{
+		DefaultIndex:  indexName,
+		InsecureSSL:   hecInsecure,
+		Timeout:       hecTimeout,
+		BatchSize:     hecBatchSize,
+		MaxBatchBytes: hecBatchBytes,
+		Concurrency:   concurrency,
+		Debug:         debugMode,
+	}
+	setAckOptions(hecOptions)
+	setGzipOptions(hecOptions)
+	setTLSOptions(hecOptions)
+	hecOptions.Endpoint = endpoint
+	hecOptions.RawEventTime = rawEventTime
+	hecClient, err := hec.NewClient(url, hecToken, hecOptions)
+	if err != nil {
+		exitRun(runSummary, err)
+	}
+
+	rateLimiter, err := newRateLimiter()
+	if err != nil {
+		exitRun(runSummary, err)
+	}
+
+	display.Println("Testing connection to Splunk HEC...")
+	if err := hecClient.HealthCheck(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to Splunk HEC: %v\n", err)
+		finishSummary(runSummary, err)
+		os.Exit(1)
+	}
+	display.Println("Connection successful!")
+
+	// Events that fail again go to this run's own dead-letter file
+	deadLetters := publish.NewDeadLetterWriter(filepath.Join(newRunDirectory(runSummary), publish.DeadLetterFileName))
+
+	p := publish.NewPublisher(&publish.PublisherConfig{
+		HECClient:       hecClient,
+		Concurrency:     concurrency,
+		BatchSize:       hecBatchSize,
+		BatchBytes:      hecBatchBytes,
+		RetryCount:      retryCount,
+		RetryWait:       retryWait,
+		RetryMaxWait:    retryMaxWait,
+		RetryMaxElapsed: retryElapsed,
+		Debug:           debugMode,
+		PreserveOrder:   preserveOrder,
+		DeadLetter:      deadLetters,
+		ReplayIndex:     indexName,
+		RateLimiter:     rateLimiter,
+	})
+
+	// Set up display
+	displayDone := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		publish.DisplayProgress(cmd.Name(), p.GetProgress(), displayDone)
+	}()
+
+	display.Printf("Replaying events from '%s' to Splunk HEC at '%s'\n", deadLetterFile, url)
+
+	// Stop cleanly on interrupt; unsent events are kept in the new dead-letter file
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = p.ReplayFile(ctx, deadLetterFile)
+	err = closeDeadLetters(deadLetters, err)
+
+	close(displayDone)
+	wg.Wait()
+
+	// Record the run summary
+	replaySummary := buildPublishSummary(p, hecClient, deadLetters)
+	replaySummary.InputFile = deadLetterFile
+	runSummary.Replay = replaySummary
+	finishSummary(runSummary, err)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nError during replay: %v\n", err)
+		if replaySummary.DeadLetterFile != "" {
+			fmt.Fprintf(os.Stderr, "Failed events written to %s\n", replaySummary.DeadLetterFile)
+		}
+		os.Exit(1)
+	}
+
+	if !textSummary() {
+		return
+	}
+
+	display.Println("\nReplay completed!")
+	printPublishSummary(p, replaySummary)
+}
+
+// deadLetterTarget returns the HEC URL and endpoint the events of a
+// dead-letter file failed against. The URL is the first one recorded, since
+// events that were never sent don't record one.
+func deadLetterTarget(path string) (url, endpoint string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("error opening dead-letter file: %w", err)
+	}
+	defer file.Close()
+
+	reader := publish.NewDeadLetterReader(file)
+	for i := 0; ; i++ {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			if i == 0 {
+				return "", "", fmt.Errorf("dead-letter file %s is empty", path)
+			}
+			return "", endpoint, nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if i == 0 {
+			endpoint = record.Endpoint
+		}
+		if record.URL != "" {
+			return record.URL, endpoint, nil
+		}
+	}
+}
@@ -3,8 +3,19 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"github.com/thezmc/spexma/internal/common/display"
+	"github.com/thezmc/spexma/internal/common/summary"
+)
+
+// Global options
+var (
+	displayMode  string = string(display.ModeAuto)
+	outputFormat string = "text"
+	summaryFile  string
+	runDirectory string = "spexma-runs"
 )
 
 var rootCmd = &cobra.Command{
@@ -15,6 +26,33 @@ It provides various subcommands to transform, filter, and split your exports.`,
 	Version: "1.0.0",
 }
 
+// setupRun applies the configuration and global flags before any command runs
+func setupRun(cmd *cobra.Command, args []string) error {
+	// Fill in flags from the environment and config file, except when
+	// showing where they would come from
+	if cmd != configShowCmd {
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+	}
+
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("invalid output format '%s' (expected text or json)", outputFormat)
+	}
+
+	// Keep stdout clean for the JSON summary unless a display mode was asked for
+	if outputFormat == "json" && !cmd.Flags().Changed("display") {
+		displayMode = string(display.ModeQuiet)
+	}
+
+	mode, err := display.ParseMode(displayMode)
+	if err != nil {
+		return err
+	}
+	display.SetMode(mode)
+	return nil
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -23,7 +61,66 @@ func Execute() {
 }
 
 func init() {
+	// Set here since setupRun refers back to rootCmd
+	rootCmd.PersistentPreRunE = setupRun
+
+	rootCmd.PersistentFlags().StringVar(&displayMode, "display", displayMode, "Progress display mode: auto, tty, plain, json or quiet")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", outputFormat, "Run summary format on stdout: text or json")
+	rootCmd.PersistentFlags().StringVar(&summaryFile, "summary-file", "", "Write a JSON run summary to this file")
+	rootCmd.PersistentFlags().StringVar(&runDirectory, "run-directory", runDirectory, "Directory under which each run keeps its files, such as dead-letter events")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Config file with settings and profiles (default "+defaultConfigPath()+", or set "+envPrefix+"CONFIG)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Config file profile to use (or set "+envPrefix+"PROFILE; defaults to the file's default_profile)")
+
 	rootCmd.AddCommand(splitCmd)
 	rootCmd.AddCommand(publishCmd)
 	rootCmd.AddCommand(hecTestCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// newSummary starts a run summary for cmd, recording its effective flags
+func newSummary(cmd *cobra.Command) *summary.Summary {
+	s := summary.New(cmd.Name())
+	s.SetConfig(cmd.Flags())
+	return s
+}
+
+// newRunDirectory returns the directory for files kept by this run, named
+// after the command and its start time. It is only created when needed.
+func newRunDirectory(s *summary.Summary) string {
+	return filepath.Join(runDirectory, s.Command+"-"+s.StartedAt.Format("20060102T150405.000Z"))
+}
+
+// textSummary reports whether the human-readable summary should be printed
+func textSummary() bool {
+	return outputFormat == "text"
+}
+
+// exitRun ends a run that failed before it got going: the error is reported
+// and recorded in the run summary
+func exitRun(s *summary.Summary, err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	finishSummary(s, err)
+	os.Exit(1)
+}
+
+// finishSummary completes a run summary and writes it to --summary-file and,
+// with --output json, to stdout
+func finishSummary(s *summary.Summary, runErr error) {
+	s.Finish(runErr)
+
+	if summaryFile != "" {
+		if err := s.WriteFile(summaryFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+
+	if outputFormat == "json" {
+		data, err := s.Marshal()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		os.Stdout.Write(data)
+	}
 }
@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/spf13/cobra"
+	"github.com/thezmc/spexma/internal/common/display"
+	"github.com/thezmc/spexma/internal/common/summary"
 	"github.com/thezmc/spexma/internal/split"
 )
 
@@ -15,6 +19,15 @@ var (
 	inputFile       string
 	outputDirectory string
 	sourcetypeCol   string = "sourcetype" // Default column name
+
+	// S3-compatible output options, used when the output directory is an s3:// URL
+	s3Endpoint  string
+	s3AccessKey string
+	s3SecretKey string
+	s3Region    string
+	s3NoSSL     bool
+	s3PartSize  int = split.DefaultS3PartSize / (1024 * 1024)
+	s3MaxMemory int = split.DefaultS3MaxMemory / (1024 * 1024)
 )
 
 // splitCmd represents the split command
@@ -24,8 +37,12 @@ var splitCmd = &cobra.Command{
 	Long: `Split a Splunk CSV export into multiple CSV files, one for each sourcetype.
 Each output file will only contain columns that are relevant for that sourcetype.
 
+The output directory may be an s3://bucket/prefix URL, in which case files are
+streamed to an S3-compatible object store using multipart uploads.
+
 Example:
-  spexma split -i export.csv -o ./output_dir`,
+  spexma split -i export.csv -o ./output_dir
+  spexma split -i export.csv -o s3://splunk-exports/run1 --s3-endpoint minio:9000`,
 	Run: runSplit,
 }
 
@@ -35,30 +52,70 @@ func init() {
 	splitCmd.Flags().StringVarP(&outputDirectory, "output-directory", "o", "", "Output directory for the split CSV files (defaults to same directory as input)")
 	splitCmd.Flags().StringVarP(&sourcetypeCol, "column", "c", sourcetypeCol, "Column name for sourcetype")
 
+	// S3-compatible output options
+	splitCmd.Flags().StringVar(&s3Endpoint, "s3-endpoint", "", "S3-compatible endpoint (host:port) for s3:// output")
+	splitCmd.Flags().StringVar(&s3AccessKey, "s3-access-key", "", "S3 access key (defaults to $AWS_ACCESS_KEY_ID)")
+	splitCmd.Flags().StringVar(&s3SecretKey, "s3-secret-key", "", "S3 secret key (defaults to $AWS_SECRET_ACCESS_KEY)")
+	splitCmd.Flags().StringVar(&s3Region, "s3-region", "", "S3 region (defaults to $AWS_REGION)")
+	splitCmd.Flags().BoolVar(&s3NoSSL, "s3-no-ssl", false, "Connect to the S3 endpoint over plain HTTP")
+	splitCmd.Flags().IntVar(&s3PartSize, "s3-part-size", s3PartSize, "Multipart upload part size in MiB (5 to 5120); each sourcetype's upload holds one part in memory, and objects can have up to 10000 parts")
+	splitCmd.Flags().IntVar(&s3MaxMemory, "s3-max-memory", s3MaxMemory, "Memory in MiB for the part buffers of all sourcetypes' uploads, beyond which they get smaller parts (0 for no limit)")
+
 	// Mark required flags
-	splitCmd.MarkFlagRequired("input-file")
+	markRequired(splitCmd, "input-file")
 }
 
 func runSplit(cmd *cobra.Command, args []string) {
+	runSummary := newSummary(cmd)
+
 	// Validate input file
 	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
-		fmt.Printf("Error: Input file '%s' does not exist\n", inputFile)
-		os.Exit(1)
+		exitRun(runSummary, fmt.Errorf("input file '%s' does not exist", inputFile))
 	}
 
-	// If output directory is not provided, use the same directory as the input file
-	if outputDirectory == "" {
+	// Set up the output sink
+	var sink split.Sink
+	if strings.HasPrefix(outputDirectory, "s3://") {
+		if s3Endpoint == "" {
+			exitRun(runSummary, errors.New("--s3-endpoint is required for s3:// output"))
+		}
+		if s3PartSize < split.MinS3PartSize/(1024*1024) || s3PartSize > split.MaxS3PartSize/(1024*1024) {
+			exitRun(runSummary, fmt.Errorf("--s3-part-size must be between %d and %d MiB", split.MinS3PartSize/(1024*1024), split.MaxS3PartSize/(1024*1024)))
+		}
+		if s3MaxMemory < 0 {
+			exitRun(runSummary, errors.New("--s3-max-memory can't be negative"))
+		}
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(outputDirectory, "s3://"), "/")
+		s3Sink, err := split.NewS3Sink(&split.S3SinkOptions{
+			Endpoint:  s3Endpoint,
+			Bucket:    bucket,
+			Prefix:    prefix,
+			AccessKey: valueOrEnv(s3AccessKey, "AWS_ACCESS_KEY_ID"),
+			SecretKey: valueOrEnv(s3SecretKey, "AWS_SECRET_ACCESS_KEY"),
+			Region:    valueOrEnv(s3Region, "AWS_REGION"),
+			UseSSL:    !s3NoSSL,
+			PartSize:  uint64(s3PartSize) * 1024 * 1024,
+			MaxMemory: uint64(s3MaxMemory) * 1024 * 1024,
+		})
+		if err != nil {
+			exitRun(runSummary, fmt.Errorf("error setting up S3 output: %w", err))
+		}
+		sink = s3Sink
+	} else if outputDirectory == "" {
+		// If output directory is not provided, use the same directory as the input file
 		outputDirectory = filepath.Dir(inputFile)
 	} else {
 		// Ensure output directory exists
 		if _, err := os.Stat(outputDirectory); os.IsNotExist(err) {
-			fmt.Printf("Creating output directory: %s\n", outputDirectory)
+			display.Printf("Creating output directory: %s\n", outputDirectory)
 			if err := os.MkdirAll(outputDirectory, 0o755); err != nil {
-				fmt.Printf("Error creating output directory '%s': %v\n", outputDirectory, err)
-				os.Exit(1)
+				exitRun(runSummary, fmt.Errorf("error creating output directory '%s': %w", outputDirectory, err))
 			}
 		}
 	}
+	if sink == nil {
+		sink = split.NewFileSink(outputDirectory)
+	}
 
 	// Create stats tracker
 	statsTracker := split.NewStats()
@@ -78,10 +135,13 @@ func runSplit(cmd *cobra.Command, args []string) {
 	}()
 
 	// Process the CSV
-	fmt.Println("Processing CSV file:", inputFile)
-	fmt.Println("Output directory:", outputDirectory)
-	fmt.Println("This will overwrite any existing CSV files with the same sourcetype names.")
-	err := split.ProcessCSV(inputFile, outputDirectory, sourcetypeCol, statsTracker, &wg)
+	display.Println("Processing CSV file:", inputFile)
+	display.Println("Output directory:", outputDirectory)
+	display.Println("This will overwrite any existing CSV files with the same sourcetype names.")
+	err := split.ProcessCSV(inputFile, sink, sourcetypeCol, statsTracker, &wg)
+
+	// Close out the last phase so it appears in the summary
+	statsTracker.SetProcessingPhase("completed")
 
 	// Signal display updater to stop
 	close(displayDone)
@@ -89,22 +149,63 @@ func runSplit(cmd *cobra.Command, args []string) {
 	// Wait for all goroutines to finish
 	wg.Wait()
 
-	// Print final stats
+	// Record the run summary
 	order, records := statsTracker.GetStats()
-	fmt.Println("\nProcessing completed.")
-	fmt.Println("Summary:")
-	fmt.Println("--------------------")
-	totalRecords := 0
+	phases := statsTracker.GetCompletedPhases()
+	splitSummary := &summary.Split{
+		InputFile: inputFile,
+		Output:    outputDirectory,
+	}
+	if info, statErr := os.Stat(inputFile); statErr == nil {
+		splitSummary.InputBytes = info.Size()
+	}
 	for _, st := range order {
-		fmt.Printf("%-30s: %d records\n", st, records[st])
-		totalRecords += records[st]
+		splitSummary.Sourcetypes = append(splitSummary.Sourcetypes, summary.Sourcetype{
+			Sourcetype: st,
+			Records:    records[st],
+			Output:     sink.Location(split.OutputName(st)),
+		})
+		splitSummary.TotalRecords += records[st]
+	}
+	for _, phase := range phases {
+		splitSummary.Phases = append(splitSummary.Phases, summary.Phase{
+			Phase:            phase.Phase,
+			Bytes:            phase.BytesRead,
+			Records:          phase.Records,
+			DurationSeconds:  phase.Elapsed.Seconds(),
+			BytesPerSecond:   phase.BytesPerSecond(),
+			RecordsPerSecond: phase.RecordsPerSecond(),
+		})
+	}
+	runSummary.Split = splitSummary
+	finishSummary(runSummary, err)
+
+	// Print final stats
+	if textSummary() {
+		display.Println("\nProcessing completed.")
+		display.Println("Summary:")
+		display.Println("--------------------")
+		for _, st := range order {
+			display.Printf("%-30s: %d records\n", st, records[st])
+		}
+		display.Println("--------------------")
+		display.Printf("Total: %d records processed\n", splitSummary.TotalRecords)
+		for _, phase := range phases {
+			display.Printf("%-30s: %s\n", phase.Phase, split.FormatPhaseProgress(phase))
+		}
 	}
-	fmt.Println("--------------------")
-	fmt.Printf("Total: %d records processed\n", totalRecords)
 
 	// Check for errors
 	if err != nil {
-		fmt.Printf("\nError during processing: %v\n", err)
+		fmt.Fprintf(os.Stderr, "\nError during processing: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// valueOrEnv returns value, or the named environment variable if value is empty
+func valueOrEnv(value, envVar string) string {
+	if value == "" {
+		return os.Getenv(envVar)
+	}
+	return value
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Token source options, shared by the commands that send to HEC
+var (
+	tokenFile  string
+	tokenStdin bool
+)
+
+// addTokenFlags adds the flags giving a command's HEC token
+func addTokenFlags(cmd *cobra.Command, token *string) {
+	cmd.Flags().StringVarP(token, "token", "t", "", "Splunk HEC token; visible in shell history and ps, so prefer --token-file, --token-stdin or "+envPrefix+"TOKEN")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "Read the Splunk HEC token from this file")
+	cmd.Flags().BoolVar(&tokenStdin, "token-stdin", false, "Read the Splunk HEC token from the first line of stdin")
+}
+
+// resolveToken sets token from --token-file or --token-stdin when given,
+// and checks that exactly one source of the token was used
+func resolveToken(token *string) error {
+	sources := 0
+	for _, given := range []bool{*token != "", tokenFile != "", tokenStdin} {
+		if given {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("give the HEC token with only one of --token, --token-file, --token-stdin or " + envPrefix + "TOKEN")
+	}
+
+	switch {
+	case tokenFile != "":
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading token file: %w", err)
+		}
+		*token = strings.TrimSpace(string(data))
+	case tokenStdin:
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("error reading token from stdin: %w", err)
+		}
+		*token = strings.TrimSpace(line)
+	}
+
+	if *token == "" {
+		return errors.New("a HEC token is required: use --token, --token-file, --token-stdin or " + envPrefix + "TOKEN")
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// setStdin makes input the process's stdin for the rest of the test
+func setStdin(t *testing.T, input string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.WriteString(input)
+		w.Close()
+	}()
+	setGlobal(t, &os.Stdin, r)
+}
+
+func TestResolveToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string // --token or SPEXMA_TOKEN
+		file     string // Contents of --token-file, if set
+		useStdin bool   // --token-stdin
+		stdin    string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:  "token",
+			token: "secret",
+			want:  "secret",
+		},
+		{
+			name: "file, trimmed",
+			file: "  secret\n",
+			want: "secret",
+		},
+		{
+			name:     "first line of stdin",
+			useStdin: true,
+			stdin:    "secret\nrest of the input\n",
+			want:     "secret",
+		},
+		{
+			name:     "stdin without a newline",
+			useStdin: true,
+			stdin:    "secret",
+			want:     "secret",
+		},
+		{
+			name:    "token and file",
+			token:   "secret",
+			file:    "other",
+			wantErr: "give the HEC token with only one of",
+		},
+		{
+			name:     "file and stdin",
+			file:     "secret",
+			useStdin: true,
+			stdin:    "other\n",
+			wantErr:  "give the HEC token with only one of",
+		},
+		{
+			name:    "empty file",
+			file:    "\n",
+			wantErr: "a HEC token is required",
+		},
+		{
+			name:     "empty stdin",
+			useStdin: true,
+			wantErr:  "error reading token from stdin",
+		},
+		{
+			name:    "none",
+			wantErr: "a HEC token is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobal(t, &tokenFile, "")
+			setGlobal(t, &tokenStdin, tt.useStdin)
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "token")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				tokenFile = path
+			}
+			if tt.useStdin {
+				setStdin(t, tt.stdin)
+			}
+
+			token := tt.token
+			err := resolveToken(&token)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.want {
+				t.Errorf("got token %q, want %q", token, tt.want)
+			}
+		})
+	}
+}
+
+// TestTokenSourcePrecedence checks that a token source set with higher
+// precedence replaces the others rather than conflicting with them
+func TestTokenSourcePrecedence(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		env    string // SPEXMA_TOKEN
+		flags  map[string]string
+		want   string
+	}{
+		{
+			name:   "environment over a file in the config",
+			config: "defaults:\n  token-file: " + tokenPath,
+			env:    "from-env",
+			want:   "from-env",
+		},
+		{
+			name:  "--token-file over the environment",
+			env:   "from-env",
+			flags: map[string]string{"token-file": tokenPath},
+			want:  "from-file",
+		},
+		{
+			name:   "--token over a file in the config",
+			config: "defaults:\n  token-file: " + tokenPath,
+			flags:  map[string]string{"token": "from-flag"},
+			want:   "from-flag",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfig(t, tt.config)
+			setGlobal(t, &tokenFile, "")
+			setGlobal(t, &tokenStdin, false)
+			if tt.env != "" {
+				t.Setenv("SPEXMA_TOKEN", tt.env)
+			}
+
+			// A stand-in for publish, so its flags are left alone
+			var token string
+			cmd := &cobra.Command{Use: "publish"}
+			addTokenFlags(cmd, &token)
+			for name, value := range tt.flags {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := applyConfig(cmd); err != nil {
+				t.Fatal(err)
+			}
+			if err := resolveToken(&token); err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.want {
+				t.Errorf("got token %q, want %q", token, tt.want)
+			}
+		})
+	}
+}